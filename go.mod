@@ -0,0 +1,3 @@
+module github.com/googlesamples/mugo
+
+go 1.18
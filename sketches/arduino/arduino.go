@@ -0,0 +1,78 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package arduino declares the Arduino built-in functions and constants so
+// that sketches importing it can be compiled with Go. The transpiler maps it
+// to Arduino.h, turning arduino.DigitalWrite(13, arduino.HIGH) into
+// digitalWrite(13, HIGH).
+package arduino
+
+// Pin modes.
+const (
+	INPUT        = 0
+	OUTPUT       = 1
+	INPUT_PULLUP = 2
+)
+
+// Digital values.
+const (
+	LOW  = 0
+	HIGH = 1
+)
+
+// Pins.
+const (
+	LED_BUILTIN = 13
+	A0          = 14
+	A1          = 15
+	A2          = 16
+	A3          = 17
+	A4          = 18
+	A5          = 19
+)
+
+// PinMode configures pin to behave as an input or an output.
+func PinMode(pin, mode int) {}
+
+// DigitalWrite writes HIGH or LOW to a digital pin.
+func DigitalWrite(pin, value int) {}
+
+// DigitalRead reads HIGH or LOW from a digital pin.
+func DigitalRead(pin int) int { return LOW }
+
+// AnalogRead reads the value of an analog pin, from 0 to 1023.
+func AnalogRead(pin int) int { return 0 }
+
+// AnalogWrite writes a PWM wave with the given duty cycle, from 0 to 255.
+func AnalogWrite(pin, value int) {}
+
+// Delay pauses the program for ms milliseconds.
+func Delay(ms uint32) {}
+
+// DelayMicroseconds pauses the program for us microseconds.
+func DelayMicroseconds(us uint) {}
+
+// Millis returns the number of milliseconds since the program started.
+func Millis() uint32 { return 0 }
+
+// Micros returns the number of microseconds since the program started.
+func Micros() uint32 { return 0 }
+
+// Tone generates a square wave of the given frequency on pin.
+func Tone(pin int, frequency uint) {}
+
+// NoTone stops the wave generated by Tone on pin.
+func NoTone(pin int) {}
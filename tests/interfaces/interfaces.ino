@@ -0,0 +1,12 @@
+struct counter {
+  int n;
+};
+int counter_Read(counter* c) {
+  c->n = c->n + 1;
+  return c->n;
+}
+int value = 0;
+void loop() {
+  counter r = {0};
+  value = counter_Read(&r);
+}
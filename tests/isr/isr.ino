@@ -0,0 +1,7 @@
+int ticks = 0;
+ISR(TIMER1_COMPA_vect) {
+  ticks++;
+}
+void loop() {
+  delay(ticks);
+}
@@ -0,0 +1,98 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+type Celsius int16
+
+const Freezing Celsius = 0
+
+const Boiling Celsius = 100
+
+const samples = 8
+
+var ready bool = false
+
+var count int
+
+var small int8 = -4
+
+var level uint8 = 200
+
+var wide int32 = 70000
+
+var big uint32 = 4000000000
+
+var ratio float32 = 0.5
+
+var precise float64 = 0.25
+
+var letter byte = 'a'
+
+var code rune = 'z'
+
+var name string = "probe"
+
+type Probe struct {
+	Pin     int
+	Reading Celsius
+}
+
+func (p Probe) Hot() bool {
+	return p.Reading > Boiling
+}
+
+func (p *Probe) Update(v Celsius) {
+	p.Reading = v
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	} else if v > hi {
+		return hi
+	}
+	return v
+}
+
+func loop() {
+	p := Probe{Pin: 2}
+	p.Update(Freezing + 5)
+	if p.Hot() {
+		if count > samples {
+			count = 0
+		} else {
+			count++
+		}
+	} else {
+		count--
+	}
+	for i := 0; i < samples; i++ {
+		count += i
+		count -= 1
+		count *= 2
+		count /= 2
+		count %= 100
+		level &= 0x7F
+		level |= 0x01
+		level ^= 0x10
+		level <<= 1
+		level >>= 1
+		level &^= 0x02
+	}
+	count = clamp(count, 0, 50)
+	delay(count)
+}
@@ -0,0 +1,61 @@
+typedef int16_t Celsius;
+const Celsius Freezing = 0;
+const Celsius Boiling = 100;
+const int samples = 8;
+bool ready = false;
+int count = 0;
+int8_t small = -4;
+uint8_t level = 200;
+int32_t wide = 70000;
+uint32_t big = 4000000000;
+float ratio = 0.5;
+double precise = 0.25;
+uint8_t letter = 'a';
+int32_t code = 'z';
+const char * name = "probe";
+struct Probe {
+  int Pin;
+  Celsius Reading;
+};
+bool Probe_Hot(Probe p) {
+  return p.Reading > Boiling;
+}
+void Probe_Update(Probe* p, Celsius v) {
+  p->Reading = v;
+}
+int clamp(int v, int lo, int hi) {
+  if (v < lo) {
+    return lo;
+  } else if (v > hi) {
+    return hi;
+  }
+  return v;
+}
+void loop() {
+  Probe p = {.Pin = 2};
+  Probe_Update(&p, Freezing + 5);
+  if (Probe_Hot(p)) {
+    if (count > samples) {
+      count = 0;
+    } else {
+      count++;
+    }
+  } else {
+    count--;
+  }
+  for (int i = 0; i < samples; i++) {
+    count += i;
+    count -= 1;
+    count *= 2;
+    count /= 2;
+    count %= 100;
+    level &= 0x7F;
+    level |= 0x01;
+    level ^= 0x10;
+    level <<= 1;
+    level >>= 1;
+    level &= ~0x02;
+  }
+  count = clamp(count, 0, 50);
+  delay(count);
+}
@@ -0,0 +1,69 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+type Speed int
+
+type Label string
+
+const MaxSpeed Speed = 100
+
+const Name Label = "mugo"
+
+type Sensor struct {
+	Pin    int
+	Active bool
+}
+
+var total = 0
+
+func debugf(format string, args ...interface{}) {
+}
+
+func loop() {
+	for i := 0; i < 10; i++ {
+		total += i
+	}
+	for j := 10; j > 0; j-- {
+		total -= j
+	}
+	for total < 100 {
+		total++
+	}
+	message := "hello"
+	n := len(message)
+	delay(total + n)
+	debugf("%d %s\n", total, message)
+	ptr := &total
+	*ptr = 5
+	x := *ptr
+	delay(x)
+	done := total > 100
+	if !done {
+		total = 0
+	}
+	s1 := Sensor{3, true}
+	s2 := Sensor{Active: true, Pin: 4}
+	delay(s1.Pin + s2.Pin)
+	var flags uint8 = 0xF0
+	flags = flags &^ 0x30
+	var level int = -16
+	level = level >> 2
+	if flags&0x80 == 0 || 1<<flags+1 > level {
+		total = 0
+	}
+}
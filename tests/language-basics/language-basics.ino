@@ -0,0 +1,44 @@
+typedef int Speed;
+typedef const char * Label;
+const Speed MaxSpeed = 100;
+const Label Name = "mugo";
+struct Sensor {
+  int Pin;
+  bool Active;
+};
+int total = 0;
+void debugf(const char * format, ...) {
+}
+void loop() {
+  for (int i = 0; i < 10; i++) {
+    total += i;
+  }
+  for (int j = 10; j > 0; j--) {
+    total -= j;
+  }
+  for (; total < 100; ) {
+    total++;
+  }
+  const char * message = "hello";
+  int n = strlen(message);
+  delay(total + n);
+  debugf("%d %s\n", total, message);
+  int* ptr = &total;
+  *ptr = 5;
+  int x = *ptr;
+  delay(x);
+  bool done = total > 100;
+  if (!done) {
+    total = 0;
+  }
+  Sensor s1 = {3, true};
+  Sensor s2 = {.Pin = 4, .Active = true};
+  delay(s1.Pin + s2.Pin);
+  uint8_t flags = 0xF0;
+  flags = flags & ~0x30;
+  int level = -16;
+  level = level >> 2;
+  if ((flags & 0x80) == 0 || (1 << flags) + 1 > level) {
+    total = 0;
+  }
+}
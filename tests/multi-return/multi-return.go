@@ -0,0 +1,37 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+var lastValue = 0
+
+func readSensor(pin int) (int, bool) {
+	if pin < 0 {
+		return 0, false
+	}
+	return analogRead(pin), true
+}
+
+func loop() {
+	value, ok := readSensor(3)
+	if ok {
+		lastValue = value
+	}
+	_, ok = readSensor(4)
+	if !ok {
+		lastValue = 0
+	}
+}
@@ -0,0 +1,24 @@
+int lastValue = 0;
+struct readSensor_result {
+  int r0;
+  bool r1;
+};
+readSensor_result readSensor(int pin) {
+  if (pin < 0) {
+    return {0, false};
+  }
+  return {analogRead(pin), true};
+}
+void loop() {
+  auto __mugo_r0 = readSensor(3);
+  int value = __mugo_r0.r0;
+  bool ok = __mugo_r0.r1;
+  if (ok) {
+    lastValue = value;
+  }
+  auto __mugo_r1 = readSensor(4);
+  ok = __mugo_r1.r1;
+  if (!ok) {
+    lastValue = 0;
+  }
+}
@@ -0,0 +1,175 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// Annotations are line comments of the form //mugo:name [args] which
+// direct the transpilation of the declaration they document.
+const annotationPrefix = "//mugo:"
+
+// annotation looks for the given annotation in cg and returns its
+// arguments.
+func annotation(cg *ast.CommentGroup, name string) (string, bool) {
+	if cg == nil {
+		return "", false
+	}
+	for _, c := range cg.List {
+		if !strings.HasPrefix(c.Text, annotationPrefix+name) {
+			continue
+		}
+		args := strings.TrimPrefix(c.Text, annotationPrefix+name)
+		if args != "" && args[0] != ' ' && args[0] != '\t' {
+			continue
+		}
+		return strings.TrimSpace(args), true
+	}
+	return "", false
+}
+
+// annotations returns the arguments of each occurrence of the given
+// annotation in cg.
+func annotations(cg *ast.CommentGroup, name string) []string {
+	if cg == nil {
+		return nil
+	}
+	var all []string
+	for _, c := range cg.List {
+		if args, ok := annotation(&ast.CommentGroup{List: []*ast.Comment{c}}, name); ok {
+			all = append(all, args)
+		}
+	}
+	return all
+}
+
+// hasAnnotation reports whether the comment group of comments closest
+// before pos carries the given annotation.
+func hasAnnotation(comments []*ast.CommentGroup, pos token.Pos, name string) bool {
+	var closest *ast.CommentGroup
+	for _, cg := range comments {
+		if cg != nil && cg.End() <= pos && (closest == nil || cg.End() > closest.End()) {
+			closest = cg
+		}
+	}
+	_, ok := annotation(closest, name)
+	return ok
+}
+
+// declDoc returns the doc comment of d.
+func declDoc(d ast.Decl) *ast.CommentGroup {
+	switch decl := d.(type) {
+	case *ast.FuncDecl:
+		return decl.Doc
+	case *ast.GenDecl:
+		return decl.Doc
+	default:
+		return nil
+	}
+}
+
+// declNames returns the names declared by d.
+func declNames(d ast.Decl) []string {
+	names := []string{}
+	switch decl := d.(type) {
+	case *ast.FuncDecl:
+		names = append(names, decl.Name.Name)
+	case *ast.GenDecl:
+		for _, s := range decl.Specs {
+			switch spec := s.(type) {
+			case *ast.TypeSpec:
+				names = append(names, spec.Name.Name)
+			case *ast.ValueSpec:
+				for _, n := range spec.Names {
+					names = append(names, n.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// flushComments writes the comments located between the end of the source
+// emitted so far and to, leaving annotations out.
+func flushComments(out *output, to token.Pos, indent string) {
+	if out.emitPos == nil {
+		return
+	}
+	for _, cg := range out.comments {
+		if cg.Pos() < *out.emitPos {
+			continue
+		}
+		if cg.End() > to {
+			break
+		}
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, annotationPrefix) {
+				continue
+			}
+			fmt.Fprintf(out, "%s%s\n", indent, out.comment(c.Text))
+		}
+	}
+	advance(out, to)
+}
+
+// advance records that the source up to pos has been emitted, dropping the
+// comments before it which have not been.
+func advance(out *output, pos token.Pos) {
+	if out.emitPos != nil && pos > *out.emitPos {
+		*out.emitPos = pos
+	}
+}
+
+// handleCCode emits the raw string literals of gd, a declaration annotated
+// with //mugo:c_code such as
+//
+//	//mugo:c_code
+//	var _ = `asm volatile("nop");`
+//
+// verbatim, for C++ code which has no Go equivalent. The code is neither
+// parsed nor checked. As in Go raw strings, backslashes are not escapes, so
+// C++ string literals keep theirs, but backquotes cannot appear in the code.
+// Only the blank identifier may be declared, so that Go does not see the
+// strings as values.
+func handleCCode(out *output, gd *ast.GenDecl) error {
+	for _, s := range gd.Specs {
+		vs, ok := s.(*ast.ValueSpec)
+		if !ok {
+			return fmt.Errorf("//mugo:c_code must document a var or const declaration (line %d)", out.line(gd))
+		}
+		for i, v := range vs.Values {
+			lit, ok := v.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING || !strings.HasPrefix(lit.Value, "`") || i >= len(vs.Names) || vs.Names[i].Name != "_" {
+				return fmt.Errorf("//mugo:c_code expects raw strings assigned to _ (line %d)", out.line(v))
+			}
+			code, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return err
+			}
+			if !strings.HasSuffix(code, "\n") {
+				code += "\n"
+			}
+			fmt.Fprint(out, code)
+		}
+	}
+	return nil
+}
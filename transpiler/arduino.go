@@ -0,0 +1,137 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// isArduinoMain reports whether fd is the main function, turned into setup
+// and loop with Options.ArduinoMode.
+func isArduinoMain(out *output, fd *ast.FuncDecl) bool {
+	return out.opts.ArduinoMode && fd.Recv == nil && fd.Name.Name == "main" && fd.Body != nil
+}
+
+// handleArduinoMain emits the main function fd as the setup and loop
+// functions of a sketch. The statements before the first infinite for loop
+// of main go to setup and the body of the loop to loop, which is empty if
+// there is none. Variables declared before the loop and used in it become
+// package level variables.
+func handleArduinoMain(out *output, fd *ast.FuncDecl) error {
+	for _, name := range []string{"setup", "loop"} {
+		if other := out.funcs[name]; other != nil && other != fd {
+			return fmt.Errorf("main cannot be turned into setup and loop as %s is declared at line %d", name, out.line(other))
+		}
+	}
+	stmts := fd.Body.List
+	loop := &ast.BlockStmt{Lbrace: fd.Body.Rbrace, Rbrace: fd.Body.Rbrace}
+	for i, s := range stmts {
+		fs, ok := s.(*ast.ForStmt)
+		if !ok || fs.Init != nil || fs.Cond != nil || fs.Post != nil {
+			continue
+		}
+		if i < len(stmts)-1 {
+			return fmt.Errorf("statement after the infinite loop of main is unreachable (line %d)", out.line(stmts[i+1]))
+		}
+		stmts, loop = stmts[:i], fs.Body
+		break
+	}
+
+	// Variables shared with the loop are declared first and assigned in
+	// setup.
+	used := map[*ast.Object]bool{}
+	ast.Inspect(loop, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Obj != nil {
+			used[id.Obj] = true
+		}
+		return true
+	})
+	setup := &ast.BlockStmt{Lbrace: fd.Body.Lbrace, Rbrace: loop.Lbrace}
+	var globals bytes.Buffer
+	for _, s := range stmts {
+		name, value, typ := sharedVar(out, s)
+		if name == nil || !used[name.Obj] {
+			setup.List = append(setup.List, s)
+			continue
+		}
+		if typ == "" {
+			return fmt.Errorf("cannot determine the type of %s shared by setup and loop (line %d)", name, out.line(s))
+		}
+		fmt.Fprintf(&globals, "%s = %s;\n", declaration(typ, name.Name), zeroValue(out, typ))
+		out.symbols.define(name.Name, typ)
+		if value != nil {
+			setup.List = append(setup.List, &ast.AssignStmt{Lhs: []ast.Expr{name}, TokPos: s.Pos(), Tok: token.ASSIGN, Rhs: []ast.Expr{value}})
+		}
+	}
+	globals.WriteTo(out)
+
+	fmt.Fprintln(out, "void setup() {")
+	if err := handleSetupPrologue(out, fd.Pos()); err != nil {
+		return err
+	}
+	if err := handleBlockStmt(out, setup); err != nil {
+		return fmt.Errorf("error handling block statement for setup: %v", err)
+	}
+	fmt.Fprintln(out, "}")
+	fmt.Fprintln(out, "void loop() {")
+	if err := handleBlockStmt(out, loop); err != nil {
+		return fmt.Errorf("error handling block statement for loop: %v", err)
+	}
+	fmt.Fprintln(out, "}")
+	return nil
+}
+
+// sharedVar returns the variable declared by s, if it declares a single
+// one, along with its value, if any, and its C++ type.
+func sharedVar(out *output, s ast.Stmt) (*ast.Ident, ast.Expr, string) {
+	switch st := s.(type) {
+	case *ast.AssignStmt:
+		if st.Tok != token.DEFINE || len(st.Lhs) != 1 || len(st.Rhs) != 1 {
+			return nil, nil, ""
+		}
+		name, ok := st.Lhs[0].(*ast.Ident)
+		if !ok {
+			return nil, nil, ""
+		}
+		return name, st.Rhs[0], typeFromExpr(out, st.Rhs[0])
+	case *ast.DeclStmt:
+		gd, ok := st.Decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR || len(gd.Specs) != 1 {
+			return nil, nil, ""
+		}
+		vs := gd.Specs[0].(*ast.ValueSpec)
+		if len(vs.Names) != 1 || len(vs.Values) > 1 {
+			return nil, nil, ""
+		}
+		var value ast.Expr
+		if len(vs.Values) == 1 {
+			value = vs.Values[0]
+		}
+		if vs.Type == nil {
+			return vs.Names[0], value, typeFromExpr(out, value)
+		}
+		typ, err := exprTypeToType(out.probe(), vs.Type)
+		if err != nil {
+			return vs.Names[0], value, ""
+		}
+		return vs.Names[0], value, typ
+	}
+	return nil, nil, ""
+}
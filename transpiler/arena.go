@@ -0,0 +1,119 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"strconv"
+)
+
+// arenaSize returns the size in bytes requested by a package level
+// //mugo:arena annotation, or 0 if there is none.
+func arenaSize(out *output, f *ast.File) (int, error) {
+	size := 0
+	for _, cg := range f.Comments {
+		args, ok := annotation(cg, "arena")
+		if !ok || insideDecl(f, cg) {
+			continue
+		}
+		if size != 0 {
+			return 0, fmt.Errorf("duplicate //mugo:arena annotation (line %d)", out.line(cg))
+		}
+		n, err := strconv.Atoi(args)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid arena size %q (line %d)", args, out.line(cg))
+		}
+		size = n
+	}
+	ram := out.opts.MaxRAM
+	if ram == 0 {
+		ram = out.target.RAM
+	}
+	if ram > 0 && size > ram {
+		return 0, fmt.Errorf("arena of %d bytes exceeds the %d bytes of RAM", size, ram)
+	}
+	return size, nil
+}
+
+// insideDecl reports whether n is located within one of the declarations
+// of f.
+func insideDecl(f *ast.File, n ast.Node) bool {
+	for _, d := range f.Decls {
+		if n.Pos() >= d.Pos() && n.End() <= d.End() {
+			return true
+		}
+	}
+	return false
+}
+
+// writeArena writes the arena and the bump allocator new calls go through.
+// The arena is zeroed and never reused, so allocated values are zero as in
+// Go. Sizes are rounded up so that every value is aligned for any type.
+func writeArena(w io.Writer, out *output) {
+	align := "__BIGGEST_ALIGNMENT__"
+	arena := fmt.Sprintf("uint8_t __mugo_arena[%d] __attribute__((aligned(%s)));", out.arenaSize, align)
+	if out.features.alignas {
+		fmt.Fprintln(w, "#include <stddef.h>")
+		align = "alignof(max_align_t)"
+		arena = fmt.Sprintf("alignas(max_align_t) uint8_t __mugo_arena[%d];", out.arenaSize)
+	}
+	fmt.Fprintf(w, `%[2]s
+unsigned int __mugo_arena_used = 0;
+void * __mugo_alloc(unsigned int n) {
+%[1]sn = (n + %[3]s - 1) / %[3]s * %[3]s;
+%[1]sif (n > sizeof(__mugo_arena) - __mugo_arena_used) {
+%[1]s%[1]sreturn 0;
+%[1]s}
+%[1]svoid * p = __mugo_arena + __mugo_arena_used;
+%[1]s__mugo_arena_used += n;
+%[1]sreturn p;
+}
+`, out.indentStr, arena, align)
+}
+
+// handleNew emits a call to new. With an arena, the value is allocated from
+// it. Otherwise it is allocated on the heap with Options.UseHeap, or is a
+// static variable, zeroed as in Go but shared by all the evaluations of the
+// call.
+func handleNew(out *output, c *ast.CallExpr) error {
+	if len(c.Args) != 1 {
+		return fmt.Errorf("unsupported # of new args: %v", c.Args)
+	}
+	t, err := exprTypeToType(out, c.Args[0])
+	if err != nil {
+		return err
+	}
+	switch {
+	case out.arenaSize > 0:
+		fmt.Fprintf(out, "(%[1]s*)__mugo_alloc(sizeof(%[1]s))", t)
+	case out.opts.UseHeap:
+		// The parentheses around the type allow pointer types, and
+		// those after it value-initialize, that is zero, the value.
+		fmt.Fprintf(out, "new (%s)()", t)
+	default:
+		if out.before == nil {
+			return unsupported(out, c, "new outside of a declaration requires an arena or Options.UseHeap (line %d)", out.line(c))
+		}
+		name := fmt.Sprintf("__mugo_new%d", *out.tmps)
+		*out.tmps++
+		fmt.Fprintf(out.before, "static %s;\n", declaration(t, name))
+		fmt.Fprintf(out, "&%s", name)
+	}
+	return nil
+}
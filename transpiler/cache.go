@@ -0,0 +1,127 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultCacheDir is the directory of the cache when Options.CacheDir is
+// empty.
+const defaultCacheDir = ".mugo_cache"
+
+// cacheFile is the name of the file of the cache directory mapping input
+// files to the hash of their last transpilation.
+const cacheFile = "hash.json"
+
+// cacheEntry records the last successful transpilation of a file.
+type cacheEntry struct {
+	// Hash is the SHA-256 of the input and of the options, which both
+	// determine the output.
+	Hash string `json:"hash"`
+	// Output is the file the result was written to.
+	Output string `json:"output"`
+}
+
+// cachePath returns the path of the cache file for the input file in. A
+// relative Options.CacheDir is relative to the directory of in.
+func cachePath(in string, opts *Options) string {
+	dir := defaultCacheDir
+	if opts != nil && opts.CacheDir != "" {
+		dir = opts.CacheDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(in), dir)
+	}
+	return filepath.Join(dir, cacheFile)
+}
+
+// cacheHash returns the hash of transpiling src with opts.
+func cacheHash(src []byte, opts *Options) (string, error) {
+	o, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(src)
+	h.Write(o)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readCache returns the entries of the cache file at path, keyed by the
+// absolute path of the input files. A missing file is an empty cache.
+func readCache(path string) (map[string]cacheEntry, error) {
+	entries := map[string]cacheEntry{}
+	bs, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &entries); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// cached reports whether in was last transpiled to out with the given hash
+// and out still exists, in which case it is up to date.
+func cached(path, in, out, hash string) bool {
+	entries, err := readCache(path)
+	if err != nil {
+		return false
+	}
+	key, err := filepath.Abs(in)
+	if err != nil {
+		return false
+	}
+	e, ok := entries[key]
+	if !ok || e.Hash != hash || e.Output != out {
+		return false
+	}
+	_, err = os.Stat(out)
+	return err == nil
+}
+
+// updateCache records in the cache file at path that in was transpiled to
+// out with the given hash.
+func updateCache(path, in, out, hash string) error {
+	entries, err := readCache(path)
+	if err != nil {
+		// A corrupted cache is rebuilt.
+		entries = map[string]cacheEntry{}
+	}
+	key, err := filepath.Abs(in)
+	if err != nil {
+		return err
+	}
+	entries[key] = cacheEntry{Hash: hash, Output: out}
+	bs, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, bs, 0644)
+}
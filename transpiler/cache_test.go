@@ -0,0 +1,80 @@
+package transpiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "sketch.go")
+	out := filepath.Join(dir, "sketch.cc")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(want string) {
+		t.Helper()
+		bs, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("failed to read the output: %v", err)
+		}
+		if string(bs) != want {
+			t.Errorf("expected %q, got %q", want, bs)
+		}
+	}
+
+	write(in, "package main\n\nconst ledPin = 13\n")
+	if err := TranspileFile(in, "", nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	check("const int ledPin = 13;\n")
+	if _, err := os.Stat(filepath.Join(dir, ".mugo_cache", "hash.json")); err != nil {
+		t.Errorf("expected the cache to be written: %v", err)
+	}
+
+	// The output is left as is while the input is unchanged.
+	write(out, "stale")
+	if err := TranspileFile(in, "", nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	check("stale")
+
+	// Changing the options invalidates the cache, as does disabling it.
+	if err := TranspileFile(in, "", &Options{Target: "avr"}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	check("#include <stdint.h>\nconst int16_t ledPin = 13;\n")
+	write(out, "stale")
+	if err := TranspileFile(in, "", &Options{Target: "avr", NoCache: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	check("#include <stdint.h>\nconst int16_t ledPin = 13;\n")
+
+	// With TypeCheck, the packages imported may have changed.
+	for i := 0; i < 2; i++ {
+		write(out, "stale")
+		if err := TranspileFile(in, "", &Options{Target: "avr", TypeCheck: true}); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		check("#include <stdint.h>\nconst int16_t ledPin = 13;\n")
+	}
+
+	// So does changing the input.
+	write(in, "package main\n\nconst ledPin = 12\n")
+	if err := TranspileFile(in, "", nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	check("const int ledPin = 12;\n")
+
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	if err := TranspileFile(in, "", &Options{CacheDir: cacheDir}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "hash.json")); err != nil {
+		t.Errorf("expected the cache to be written to CacheDir: %v", err)
+	}
+}
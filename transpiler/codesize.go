@@ -0,0 +1,68 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"go/ast"
+)
+
+// Rough number of bytes of AVR machine code generated for each construct.
+const (
+	funcSize   = 4 // prologue, epilogue and return
+	assignSize = 2
+	callSize   = 4
+	argSize    = 2
+	opSize     = 2
+	branchSize = 2
+	returnSize = 2
+)
+
+// EstimateCodeSize returns a rough estimate of the size in bytes of the
+// machine code generated for each function of f. It is not meant to be
+// accurate, only to flag functions that are obviously too large for the
+// flash of the target.
+func EstimateCodeSize(f *ast.File) map[string]int {
+	sizes := map[string]int{}
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		name := fd.Name.Name
+		if t, _, ok := receiverType(fd); ok {
+			name = methodName(t, name)
+		}
+		size := funcSize
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			switch node := n.(type) {
+			case *ast.AssignStmt, *ast.IncDecStmt:
+				size += assignSize
+			case *ast.CallExpr:
+				size += callSize + argSize*len(node.Args)
+			case *ast.BinaryExpr, *ast.UnaryExpr:
+				size += opSize
+			case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt:
+				size += branchSize
+			case *ast.ReturnStmt:
+				size += returnSize
+			}
+			return true
+		})
+		sizes[name] = size
+	}
+	return sizes
+}
@@ -0,0 +1,53 @@
+package transpiler
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func assignments(n int) string {
+	src := "package main\nfunc setup() {\n"
+	for i := 0; i < n; i++ {
+		src += fmt.Sprintf("\tx = %d\n", i)
+	}
+	return src + "}\n"
+}
+
+func TestEstimateCodeSize(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100} {
+		f, err := parser.ParseFile(token.NewFileSet(), "sketch.go", assignments(n), 0)
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+		size, ok := EstimateCodeSize(f)["setup"]
+		if !ok {
+			t.Fatalf("no estimate for setup in %v", EstimateCodeSize(f))
+		}
+		if min, max := 2*n, 4*n+16; size < min || size > max {
+			t.Errorf("estimate for %d assignments is %d, expected between %d and %d", n, size, min, max)
+		}
+	}
+}
+
+func TestMaxCodeSize(t *testing.T) {
+	for _, tt := range []struct {
+		max     int
+		warning bool
+	}{
+		{0, false},
+		{1000, false},
+		{100, true},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(assignments(100)), &Options{MaxCodeSize: tt.max}); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if warning := strings.HasPrefix(out.String(), "#warning"); warning != tt.warning {
+			t.Errorf("with MaxCodeSize %d expected warning %v, got:\n%s", tt.max, tt.warning, out.String())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// compileCommand returns the command running the compiler. Tests replace
+// it to avoid depending on a cross compiler.
+var compileCommand = exec.Command
+
+// Compile compiles src, the output of Transpile with the same opts, to the
+// object file out with opts.Compiler, after the Arduino.h and stdint.h
+// headers which sketches are compiled with. The compiler reads src from its
+// standard input, which its error messages refer to as <stdin>, unless src
+// has the #line directives of Options.LineDirectives, in which case they
+// refer to the Go source. A nil opts is the same as an empty Options.
+func Compile(src []byte, out string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	features, ok := stdFeatures(opts.CppStandard)
+	if !ok {
+		return fmt.Errorf("unknown standard %q", opts.CppStandard)
+	}
+	target := getTargetConfig(opts.Target)
+	if target == nil {
+		return fmt.Errorf("unknown target %q", opts.Target)
+	}
+	var code bytes.Buffer
+	// Unlike the Arduino IDE, the compiler does not include them itself.
+	if !includes(target, `"Arduino.h"`) {
+		fmt.Fprintln(&code, "#include <Arduino.h>")
+	}
+	if !includes(target, "<stdint.h>") {
+		fmt.Fprintln(&code, "#include <stdint.h>")
+	}
+	code.Write(src)
+	args := strings.Fields(opts.Compiler)
+	if len(args) == 0 {
+		args = []string{"avr-gcc"}
+	}
+	mcu := opts.MCU
+	if mcu == "" {
+		mcu = "atmega328p"
+	}
+	freq := opts.CPUFrequency
+	if freq == 0 {
+		freq = 16000000
+	}
+	lang := "c++"
+	if !features.cpp {
+		lang = "c"
+	}
+	args = append(args, "-mmcu="+mcu, fmt.Sprintf("-DF_CPU=%dUL", freq), "-Os")
+	if opts.CppStandard != "" {
+		args = append(args, "-std="+opts.CppStandard)
+	}
+	args = append(args, "-x", lang, "-c", "-o", out, "-")
+	cmd := compileCommand(args[0], args[1:]...)
+	cmd.Stdin = &code
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v\n%s", args[0], err, stderr.String())
+	}
+	return nil
+}
+
+// CompileFile transpiles the Go source file in with #line directives and
+// compiles the result to the object file out as Compile does, so that the
+// errors of the compiler refer to in.
+func CompileFile(in, out string, opts *Options) error {
+	src, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.LineDirectives = true
+	if o.TypeCheck && o.Dir == "" {
+		o.Dir = filepath.Dir(in)
+	}
+	var buf bytes.Buffer
+	if _, err := transpileNamed(&buf, in, src, &o); err != nil {
+		return err
+	}
+	return Compile(buf.Bytes(), out, &o)
+}
+
+// includes reports whether target includes header, given with its
+// delimiters.
+func includes(target *TargetConfig, header string) bool {
+	for _, inc := range target.Includes {
+		if inc == header {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package transpiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	defer func(cmd func(string, ...string) *exec.Cmd) { compileCommand = cmd }(compileCommand)
+
+	var src bytes.Buffer
+	if err := Transpile(&src, strings.NewReader(unformatted), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	var got []string
+	// The fake compiler copies its input to the object file.
+	compileCommand = func(name string, args ...string) *exec.Cmd {
+		got = append([]string{name}, args...)
+		return exec.Command("sh", "-c", `cat >"$0"`, args[len(args)-2])
+	}
+	obj := filepath.Join(t.TempDir(), "sketch.o")
+	if err := Compile(src.Bytes(), obj, nil); err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	want := []string{"avr-gcc", "-mmcu=atmega328p", "-DF_CPU=16000000UL", "-Os", "-x", "c++", "-c", "-o", obj, "-"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the compiler to be run as %q, got %q", want, got)
+	}
+	headers := "#include <Arduino.h>\n#include <stdint.h>\n"
+	if bs, err := os.ReadFile(obj); err != nil || string(bs) != headers+src.String() {
+		t.Errorf("expected the compiler to read the transpiled code after its headers, got %q (%v)", bs, err)
+	}
+
+	opts := &Options{Compiler: "avr-g++ -Wall", MCU: "atmega2560", CPUFrequency: 8000000, CppStandard: "c99"}
+	if err := Compile(src.Bytes(), obj, opts); err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	want = []string{"avr-g++", "-Wall", "-mmcu=atmega2560", "-DF_CPU=8000000UL", "-Os", "-std=c99", "-x", "c", "-c", "-o", obj, "-"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the compiler to be run as %q, got %q", want, got)
+	}
+
+	compileCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", `echo "<stdin>:2:3: error: 'delay' was not declared in this scope" >&2; exit 1`)
+	}
+	err := Compile(src.Bytes(), obj, nil)
+	if err == nil || !strings.Contains(err.Error(), "<stdin>:2:3: error: 'delay' was not declared") {
+		t.Errorf("expected the compiler errors, got %v", err)
+	}
+}
+
+// hostCompiler returns the options compiling with g++ and the Arduino stub
+// instead of avr-gcc and the Arduino core, along with the command running
+// it. The test is skipped if g++ is not available.
+func hostCompiler(t *testing.T) (*Options, func(string, ...string) *exec.Cmd) {
+	if _, err := exec.LookPath("g++"); err != nil {
+		t.Skip("g++ is not available")
+	}
+	opts := &Options{Compiler: "g++ -I" + stubDir(t)}
+	return opts, func(name string, args ...string) *exec.Cmd {
+		var host []string
+		for _, a := range args {
+			if !strings.HasPrefix(a, "-mmcu=") {
+				host = append(host, a)
+			}
+		}
+		return exec.Command(name, host...)
+	}
+}
+
+// stubDir returns a directory holding arduinoStub as Arduino.h.
+func stubDir(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Arduino.h"), []byte(arduinoStub), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCompileFile(t *testing.T) {
+	opts, cmd := hostCompiler(t)
+	defer func(cmd func(string, ...string) *exec.Cmd) { compileCommand = cmd }(compileCommand)
+	compileCommand = cmd
+	dir := t.TempDir()
+	obj := filepath.Join(dir, "blink.o")
+	if err := CompileFile(filepath.Join(sketchDir, "blink", "blink.go"), obj, opts); err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if _, err := os.Stat(obj); err != nil {
+		t.Errorf("expected an object file: %v", err)
+	}
+
+	// The errors of the compiler point at the Go source.
+	in := filepath.Join(dir, "beep.go")
+	src := "package main\n\nfunc setup() {\n\tpinMode(8, OUTPUT)\n}\n\nfunc loop() {\n\ttone(8, 440)\n}\n"
+	if err := os.WriteFile(in, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := CompileFile(in, obj, opts)
+	if want := in + ":8:"; err == nil || !strings.Contains(err.Error(), want) || !strings.Contains(err.Error(), "tone") {
+		t.Errorf("expected an error about tone at %s, got %v", want, err)
+	}
+}
+
+// TestCompileAVR compiles a sketch with avr-gcc, with the Arduino stub
+// standing for the Arduino core.
+func TestCompileAVR(t *testing.T) {
+	if _, err := exec.LookPath("avr-gcc"); err != nil {
+		t.Skip("avr-gcc is not available")
+	}
+	obj := filepath.Join(t.TempDir(), "blink.o")
+	for _, target := range []string{"", "avr"} {
+		opts := &Options{Compiler: "avr-gcc -I" + stubDir(t), Target: target}
+		if err := CompileFile(filepath.Join(sketchDir, "blink", "blink.go"), obj, opts); err != nil {
+			t.Errorf("failed to compile for target %q: %v", target, err)
+		}
+	}
+}
+
+// arduinoStub declares the parts of the Arduino API the golden tests use,
+// so that their output compiles on the host.
+const arduinoStub = `#include <stdint.h>
+#include <string.h>
+#define HIGH 1
+#define LOW 0
+#define INPUT 0
+#define OUTPUT 1
+#define ISR(vector) extern "C" void vector(void)
+void pinMode(uint8_t pin, uint8_t mode);
+void digitalWrite(uint8_t pin, uint8_t value);
+int digitalRead(uint8_t pin);
+int analogRead(uint8_t pin);
+void analogWrite(uint8_t pin, int value);
+void delay(unsigned long ms);
+`
+
+// checkSyntax compiles code after arduinoStub with g++ using the standard
+// std, or with gcc if std is a C one, and returns the messages of the
+// compiler if it fails. The test is skipped if the compiler is not
+// available.
+func checkSyntax(t *testing.T, std, code string) (string, error) {
+	compiler, lang := "g++", "c++"
+	if strings.HasPrefix(std, "c") && !strings.HasPrefix(std, "c++") {
+		compiler, lang = "gcc", "c"
+	}
+	path, err := exec.LookPath(compiler)
+	if err != nil {
+		t.Skipf("%s is not available", compiler)
+	}
+	dir := t.TempDir()
+	stub := filepath.Join(dir, "arduino_stub.h")
+	if err := os.WriteFile(stub, []byte(arduinoStub), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "sketch."+map[string]string{"c": "c", "c++": "cc"}[lang])
+	if err := os.WriteFile(src, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The stub is included by the compiler to keep the line numbers of
+	// the errors those of the code.
+	msg, err := exec.Command(path, "-std="+std, "-x", lang, "-fsyntax-only", "-include", stub, src).CombinedOutput()
+	return string(msg), err
+}
+
+func TestCompileOutput(t *testing.T) {
+	for _, s := range append(sketches, tests...) {
+		src := filepath.Join(sketchDir, s, s+".go")
+		if _, err := os.Stat(src); err != nil {
+			src = filepath.Join(testDir, s, s+".go")
+		}
+		t.Run(s, func(t *testing.T) {
+			bs, err := os.ReadFile(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out, symbols bytes.Buffer
+			if _, err := TranspileBytes(&out, bs, &Options{SymbolMap: &symbols}); err != nil {
+				t.Fatalf("failed to transpile %s: %v", src, err)
+			}
+			if msg, err := checkSyntax(t, "c++11", out.String()); err != nil {
+				var m map[string]Symbol
+				if err := json.Unmarshal(symbols.Bytes(), &m); err != nil {
+					t.Fatalf("failed to read the symbol map: %v", err)
+				}
+				t.Errorf("failed to compile the output of %s: %v\n%s-- failing lines:\n%s-- output:\n%s", src, err, msg, goLines(out.String(), msg, m), numberLines(out.String()))
+			}
+		})
+	}
+}
+
+func TestStringConst(t *testing.T) {
+	const src = `package main
+
+const greeting = "hello"
+
+var name = greeting
+
+func loop() {
+	name = greeting
+}
+`
+	const want = `const char * const greeting = "hello";
+const char * name = greeting;
+void loop() {
+  name=greeting;
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+	if msg, err := checkSyntax(t, "c++11", out.String()); err != nil {
+		t.Errorf("failed to compile the output: %v\n%s", err, msg)
+	}
+}
+
+// compileError matches the errors reported by checkSyntax, capturing the
+// line of the code they are at.
+var compileError = regexp.MustCompile(`sketch\.cc?:(\d+):\d+: error:`)
+
+// goLines lists the lines of code the compiler messages msg report errors
+// at, along with the Go function they were transpiled from according to
+// the symbol map symbols, and the line it starts at.
+func goLines(code, msg string, symbols map[string]Symbol) string {
+	lines := strings.Split(code, "\n")
+	var b strings.Builder
+	for _, m := range compileError.FindAllStringSubmatch(msg, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n < 1 || n > len(lines) {
+			continue
+		}
+		fmt.Fprintf(&b, "%4d  %s\n      ", n, lines[n-1])
+		fn, sym := enclosingFunc(lines[:n], symbols)
+		if fn == "" {
+			b.WriteString("outside of functions\n")
+			continue
+		}
+		fmt.Fprintf(&b, "in %s at %s:%d\n", fn, sym.File, sym.Line)
+	}
+	return b.String()
+}
+
+// enclosingFunc returns the Go name and the symbol of the function the last
+// of lines belongs to, or "" if it is outside of functions. Only the
+// statements and the braces closing nested blocks are indented, if at all,
+// in function bodies, so any other unindented line is a declaration.
+func enclosingFunc(lines []string, symbols map[string]Symbol) (string, Symbol) {
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := lines[i]
+		if l == "" || strings.HasPrefix(l, " ") || strings.HasPrefix(l, "}") && !strings.HasPrefix(l, "};") {
+			continue
+		}
+		for fn, sym := range symbols {
+			if strings.HasSuffix(l, "{") && strings.Contains(l, " "+sym.Name+"(") {
+				return fn, sym
+			}
+		}
+		return "", Symbol{}
+	}
+	return "", Symbol{}
+}
+
+// numberLines prefixes each line of s with its number.
+func numberLines(s string) string {
+	var b strings.Builder
+	for i, l := range strings.SplitAfter(s, "\n") {
+		if l != "" {
+			fmt.Fprintf(&b, "%4d  %s", i+1, l)
+		}
+	}
+	return b.String()
+}
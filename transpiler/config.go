@@ -0,0 +1,48 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ConfigFile is the name of the file options are read from, in the root
+// directory of a project.
+const ConfigFile = ".mugo.json"
+
+// LoadConfig reads Options from the JSON file at path, whose keys are the
+// names of the Options fields. It returns an empty Options if the file does
+// not exist.
+func LoadConfig(path string) (*Options, error) {
+	opts := &Options{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return opts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(opts); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return opts, nil
+}
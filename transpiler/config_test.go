@@ -0,0 +1,44 @@
+package transpiler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ConfigFile)
+
+	opts, err := LoadConfig(path)
+	if err != nil || opts == nil {
+		t.Fatalf("expected empty options for a missing file, got %v, %v", opts, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"IndentWidth": 4, "TypeMap": {"Servo": "Servo"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if opts.IndentWidth != 4 || opts.TypeMap["Servo"] != "Servo" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader("package main\n\nfunc loop() {\n\tdelay(1)\n}\n"), opts); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if want := "void loop() {\n    delay(1);\n}\n"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+
+	if err := os.WriteFile(path, []byte(`{"IndentWdith": 4}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("expected an error for an unknown option")
+	}
+}
@@ -0,0 +1,106 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// handleMacroDefaults emits a macro for each parameter of fd given a
+// default value by the statements fd starts with, as in
+//
+//	if ms == 0 {
+//		ms = defaultDelay()
+//	}
+//
+// and returns the body of fd with these statements replacing the parameter
+// by the result of the macro instead, along with the macros to #undef after
+// fd. The body is returned unchanged if there are none.
+func handleMacroDefaults(out *output, name string, fd *ast.FuncDecl) (*ast.BlockStmt, []string, error) {
+	params := map[*ast.Object]string{}
+	for _, f := range fd.Type.Params.List {
+		// The type was already checked with the arguments of fd.
+		typ, _ := exprTypeToType(out.probe(), f.Type)
+		for _, n := range f.Names {
+			params[n.Obj] = typ
+		}
+	}
+	body := *fd.Body
+	body.List = append([]ast.Stmt{}, fd.Body.List...)
+	var macros []string
+	for i, s := range body.List {
+		param, zero, value, ok := defaultArg(s, params)
+		if !ok {
+			break
+		}
+		var z, v bytes.Buffer
+		switch typ := params[param.Obj]; {
+		case isNil(zero) && typ == cErrorType(out):
+			fmt.Fprint(&z, nilError(out))
+		case isNil(zero):
+			fmt.Fprint(&z, zeroValue(out, typ))
+		default:
+			if err := handleExpr(out.to(&z), zero); err != nil {
+				return nil, nil, fmt.Errorf("error handling the zero value of %s: %v", param.Name, err)
+			}
+		}
+		if err := handleExpr(out.to(&v), value); err != nil {
+			return nil, nil, fmt.Errorf("error handling the default value of %s: %v", param.Name, err)
+		}
+		macro := strings.ToUpper(name + "_default_" + param.Name)
+		fmt.Fprintf(out, "#define %s(val) ((val) == (%s) ? (%s) : (val))\n", macro, z.String(), v.String())
+		macros = append(macros, macro)
+		body.List[i] = &ast.AssignStmt{
+			Lhs: []ast.Expr{param},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{&ast.CallExpr{Fun: ast.NewIdent(macro), Args: []ast.Expr{param}}},
+		}
+	}
+	return &body, macros, nil
+}
+
+// defaultArg returns the parameter s sets to value if it equals zero, when
+// s is an if statement doing only that.
+func defaultArg(s ast.Stmt, params map[*ast.Object]string) (param *ast.Ident, zero, value ast.Expr, ok bool) {
+	is, ok := s.(*ast.IfStmt)
+	if !ok || is.Init != nil || is.Else != nil || len(is.Body.List) != 1 {
+		return nil, nil, nil, false
+	}
+	cond, ok := is.Cond.(*ast.BinaryExpr)
+	if !ok || cond.Op != token.EQL {
+		return nil, nil, nil, false
+	}
+	param, ok = cond.X.(*ast.Ident)
+	if !ok || param.Obj == nil {
+		return nil, nil, nil, false
+	}
+	if _, ok := params[param.Obj]; !ok {
+		return nil, nil, nil, false
+	}
+	as, ok := is.Body.List[0].(*ast.AssignStmt)
+	if !ok || as.Tok != token.ASSIGN || len(as.Lhs) != 1 || len(as.Rhs) != 1 {
+		return nil, nil, nil, false
+	}
+	if lhs, ok := as.Lhs[0].(*ast.Ident); !ok || lhs.Obj != param.Obj {
+		return nil, nil, nil, false
+	}
+	return param, cond.Y, as.Rhs[0], true
+}
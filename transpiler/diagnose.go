@@ -0,0 +1,114 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// Severity indicates how likely a diagnostic is to be a real problem.
+type Severity int
+
+const (
+	// Info marks constructs that may allocate depending on how they are used.
+	Info Severity = iota
+	// Warning marks constructs that always allocate.
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic describes a construct that deserves the user's attention.
+type Diagnostic struct {
+	Pos      token.Pos
+	Severity Severity
+	Message  string
+}
+
+// DiagnoseAllocations reports the places in f where Go would allocate heap
+// memory. Since all memory allocation is leaked on the MCU, each of these is
+// a potential out of memory condition.
+func DiagnoseAllocations(f *ast.File) []Diagnostic {
+	var diags []Diagnostic
+	// inner tracks concatenations that are part of a longer chain which has
+	// already been reported.
+	inner := map[ast.Node]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.CallExpr:
+			if id, ok := node.Fun.(*ast.Ident); ok && id.Obj == nil && (id.Name == "make" || id.Name == "new") {
+				diags = append(diags, Diagnostic{node.Pos(), Warning, "call to " + id.Name + " allocates memory"})
+			}
+			for _, a := range node.Args {
+				if isCompositeLit(a) {
+					diags = append(diags, Diagnostic{a.Pos(), Info, "composite literal argument may allocate memory"})
+				}
+			}
+		case *ast.BinaryExpr:
+			if inner[node] || !isStringConcat(node) {
+				return true
+			}
+			inner[node.X] = true
+			inner[node.Y] = true
+			diags = append(diags, Diagnostic{node.Pos(), Warning, "string concatenation allocates memory"})
+		}
+		return true
+	})
+	return diags
+}
+
+func isCompositeLit(e ast.Expr) bool {
+	switch expr := e.(type) {
+	case *ast.CompositeLit:
+		return true
+	case *ast.UnaryExpr:
+		return expr.Op == token.AND && isCompositeLit(expr.X)
+	case *ast.ParenExpr:
+		return isCompositeLit(expr.X)
+	default:
+		return false
+	}
+}
+
+// isStringConcat reports whether be is an addition with at least one string
+// literal operand. Without type information this is the best we can do.
+func isStringConcat(be *ast.BinaryExpr) bool {
+	return be.Op == token.ADD && (isStringExpr(be.X) || isStringExpr(be.Y))
+}
+
+func isStringExpr(e ast.Expr) bool {
+	switch expr := e.(type) {
+	case *ast.BasicLit:
+		return expr.Kind == token.STRING
+	case *ast.BinaryExpr:
+		return isStringConcat(expr)
+	case *ast.ParenExpr:
+		return isStringExpr(expr.X)
+	default:
+		return false
+	}
+}
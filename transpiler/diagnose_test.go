@@ -0,0 +1,46 @@
+package transpiler
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+const allocating = `package main
+
+func setup() {
+	buf := make([]byte, 16)
+	p := new(int)
+	send(&packet{id: 1})
+	greeting := "hello, " + name + "!"
+	delay(1000)
+}
+`
+
+func TestDiagnoseAllocations(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "sketch.go", allocating, 0)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	expected := []struct {
+		line     int
+		severity Severity
+		message  string
+	}{
+		{4, Warning, "call to make allocates memory"},
+		{5, Warning, "call to new allocates memory"},
+		{6, Info, "composite literal argument may allocate memory"},
+		{7, Warning, "string concatenation allocates memory"},
+	}
+	diags := DiagnoseAllocations(f)
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diags), diags)
+	}
+	for i, e := range expected {
+		d := diags[i]
+		if line := fset.Position(d.Pos).Line; line != e.line || d.Severity != e.severity || d.Message != e.message {
+			t.Errorf("expected %d: %v: %s, got %d: %v: %s", e.line, e.severity, e.message, line, d.Severity, d.Message)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// binding is the concrete type held by an interface variable.
+type binding struct {
+	typeName string
+	pointer  bool
+	// instance is set when the variable is initialized with the address of
+	// a composite literal and never reassigned, in which case the variable
+	// can hold the value itself.
+	instance bool
+}
+
+func (b binding) String() string {
+	if b.pointer {
+		return "*" + b.typeName
+	}
+	return b.typeName
+}
+
+// bindInterfaces finds the values flowing into each interface variable of f
+// and records the concrete type of those holding a single one, so that
+// method calls on them can be dispatched statically.
+func bindInterfaces(out *output, f *ast.File) error {
+	vars := []*ast.Ident{}
+	ifaces := map[*ast.Ident]string{}
+	flows := map[*ast.Object][]ast.Expr{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.ValueSpec:
+			if !isInterface(out, node.Type) {
+				return true
+			}
+			for i, name := range node.Names {
+				vars = append(vars, name)
+				ifaces[name] = node.Type.(*ast.Ident).Name
+				if i < len(node.Values) {
+					flows[name.Obj] = append(flows[name.Obj], node.Values[i])
+				}
+			}
+		case *ast.AssignStmt:
+			if node.Tok != token.ASSIGN || len(node.Lhs) != len(node.Rhs) {
+				return true
+			}
+			for i, lhs := range node.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && id.Obj != nil {
+					flows[id.Obj] = append(flows[id.Obj], node.Rhs[i])
+				}
+			}
+		}
+		return true
+	})
+
+	for _, v := range vars {
+		values := flows[v.Obj]
+		if len(values) == 0 {
+			return fmt.Errorf("interface variable %s is never assigned", v.Name)
+		}
+		var b binding
+		for i, e := range values {
+			c, ok := concreteType(out, e)
+			if !ok {
+				return fmt.Errorf("cannot determine the concrete type assigned to interface variable %s", v.Name)
+			}
+			if i > 0 && (c.typeName != b.typeName || c.pointer != b.pointer) {
+				return fmt.Errorf("interface variable %s holds values of type %v and %v; static dispatch requires a single concrete type", v.Name, b, c)
+			}
+			b = c
+		}
+		if b.pointer && isCompositeLit(values[0]) {
+			if len(values) > 1 {
+				return fmt.Errorf("interface variable %s initialized with the address of a composite literal cannot be reassigned", v.Name)
+			}
+			b.instance = true
+		}
+		methods, err := interfaceMethods(out, ifaces[v], map[string]bool{})
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			if out.methods[b.typeName][m.Names[0].Name] == nil {
+				return fmt.Errorf("%v assigned to interface variable %s does not implement %s (missing method %s)", b, v.Name, ifaces[v], m.Names[0].Name)
+			}
+		}
+		out.bindings[v.Obj] = b
+	}
+	return nil
+}
+
+// concreteType returns the type of e when it can be determined from the
+// syntax alone.
+func concreteType(out *output, e ast.Expr) (binding, bool) {
+	switch expr := e.(type) {
+	case *ast.ParenExpr:
+		return concreteType(out, expr.X)
+	case *ast.CompositeLit:
+		id, ok := expr.Type.(*ast.Ident)
+		if !ok || isInterface(out, id) {
+			return binding{}, false
+		}
+		return binding{typeName: id.Name}, true
+	case *ast.UnaryExpr:
+		if expr.Op != token.AND {
+			return binding{}, false
+		}
+		b, ok := concreteType(out, expr.X)
+		if !ok || b.pointer {
+			return binding{}, false
+		}
+		b.pointer = true
+		return b, true
+	case *ast.Ident:
+		if expr.Obj == nil {
+			return binding{}, false
+		}
+		switch decl := expr.Obj.Decl.(type) {
+		case *ast.ValueSpec:
+			if decl.Type != nil {
+				return typeBinding(out, decl.Type)
+			}
+			for i, n := range decl.Names {
+				if n.Obj == expr.Obj && i < len(decl.Values) {
+					return concreteType(out, decl.Values[i])
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range decl.Lhs {
+				if n, ok := lhs.(*ast.Ident); ok && n.Obj == expr.Obj && len(decl.Lhs) == len(decl.Rhs) {
+					return concreteType(out, decl.Rhs[i])
+				}
+			}
+		}
+	}
+	return binding{}, false
+}
+
+// typeBinding returns the binding for a variable declared with type t.
+func typeBinding(out *output, t ast.Expr) (binding, bool) {
+	var b binding
+	if star, ok := t.(*ast.StarExpr); ok {
+		b.pointer = true
+		t = star.X
+	}
+	id, ok := t.(*ast.Ident)
+	if !ok || isInterface(out, id) {
+		return binding{}, false
+	}
+	b.typeName = id.Name
+	return b, true
+}
+
+// interfaceMethods returns the methods of the interface named name in
+// declaration order, with those of embedded interfaces in place of the
+// embedding. seen holds the interfaces being resolved.
+func interfaceMethods(out *output, name string, seen map[string]bool) ([]*ast.Field, error) {
+	if seen[name] {
+		return nil, fmt.Errorf("interface %s embeds itself", name)
+	}
+	seen[name] = true
+	defer delete(seen, name)
+	ts, ok := out.types[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown interface %s", name)
+	}
+	it, ok := ts.Type.(*ast.InterfaceType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an interface", name)
+	}
+	var methods []*ast.Field
+	names := map[string]bool{}
+	for _, f := range it.Methods.List {
+		fields := []*ast.Field{f}
+		if len(f.Names) == 0 {
+			id, ok := f.Type.(*ast.Ident)
+			if !ok {
+				return nil, fmt.Errorf("unsupported interface %s embedded in %s", types.ExprString(f.Type), name)
+			}
+			embedded, err := interfaceMethods(out, id.Name, seen)
+			if err != nil {
+				return nil, fmt.Errorf("error resolving %s embedded in %s: %v", id.Name, name, err)
+			}
+			fields = embedded
+		}
+		for _, m := range fields {
+			if !names[m.Names[0].Name] {
+				names[m.Names[0].Name] = true
+				methods = append(methods, m)
+			}
+		}
+	}
+	return methods, nil
+}
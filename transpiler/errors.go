@@ -0,0 +1,154 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// TranspileError describes a construct which is not supported by the
+// transpiler.
+type TranspileError struct {
+	Pos  token.Position
+	Node ast.Node
+	Msg  string
+}
+
+func (e TranspileError) Error() string {
+	return e.Msg
+}
+
+// ErrorList is returned by Transpile when unsupported constructs were
+// skipped because of Options.SkipUnsupported, or with every error found
+// when Options.BatchErrors is set.
+type ErrorList []TranspileError
+
+func (l ErrorList) Error() string {
+	msgs := []string{}
+	for _, e := range l {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// unsupported returns an error for the unsupported node n and records it
+// so that it can be skipped if requested. Nodes handled again, such as the
+// values whose type is unknown, which valueError handles to explain why,
+// are only recorded once.
+func unsupported(out *output, n ast.Node, format string, args ...interface{}) error {
+	e := TranspileError{
+		Pos:  out.fset.Position(n.Pos()),
+		Node: n,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+	for _, r := range *out.unsupported {
+		if r.Node == n && r.Msg == e.Msg {
+			return e
+		}
+	}
+	*out.unsupported = append(*out.unsupported, e)
+	return e
+}
+
+// syntaxError returns an error for the bad node n spanning from to to,
+// quoting the start of its code and the syntax error it stands for, and
+// records it so that it can be skipped if requested.
+func syntaxError(out *output, n ast.Node, from, to token.Pos) error {
+	span := ""
+	start, end := out.fset.Position(from).Offset, out.fset.Position(to).Offset
+	if 0 <= start && start <= end && end <= len(out.content) {
+		span = string(out.content[start:end])
+	}
+	if i := strings.IndexByte(span, '\n'); i >= 0 {
+		span = span[:i]
+	}
+	// The error is the first at or after the start of the node.
+	var cause *scanner.Error
+	for _, e := range out.parseErrors {
+		if e.Pos.Offset >= start {
+			cause = e
+			break
+		}
+	}
+	if cause == nil {
+		return unsupported(out, n, "the Go parser encountered a syntax error at %q (line %d)", span, out.line(n))
+	}
+	out.cited[cause] = true
+	return unsupported(out, n, "the Go parser encountered a syntax error at %q (line %d); the original parsing error was: %v", span, out.line(n), cause)
+}
+
+// skip reports whether the construct n which failed with err can be left
+// out of the output, in which case a comment is emitted in its place.
+// recorded is the number of unsupported constructs seen before handling it.
+// In batch mode, any error is recorded and skipped.
+func skip(out *output, n ast.Node, err error, recorded int) bool {
+	if out.opts.BatchErrors && len(*out.unsupported) == recorded {
+		*out.unsupported = append(*out.unsupported, TranspileError{
+			Pos:  out.fset.Position(n.Pos()),
+			Node: n,
+			Msg:  fmt.Sprintf("%v (line %d)", err, out.line(n)),
+		})
+	}
+	if !out.opts.SkipUnsupported && !out.opts.BatchErrors || len(*out.unsupported) == recorded {
+		return false
+	}
+	fmt.Fprintln(out, out.comment("// mugo: skipped "+(*out.unsupported)[len(*out.unsupported)-1].Msg))
+	return true
+}
+
+// nodeDescriptions describes the AST nodes users are most likely to run
+// into.
+var nodeDescriptions = map[string]string{
+	"*ast.ArrayType":      "array and slice types",
+	"*ast.ChanType":       "channel types",
+	"*ast.DeferStmt":      "defer statements",
+	"*ast.FuncLit":        "function literals",
+	"*ast.FuncType":       "function types",
+	"*ast.GoStmt":         "goroutines",
+	"*ast.IndexExpr":      "index expressions",
+	"*ast.KeyValueExpr":   "keyed composite literal elements",
+	"*ast.LabeledStmt":    "labeled statements",
+	"*ast.MapType":        "map types",
+	"*ast.RangeStmt":      "for-range loops",
+	"*ast.SelectStmt":     "select statements",
+	"*ast.SendStmt":       "channel sends",
+	"*ast.SliceExpr":      "slice expressions",
+	"*ast.SwitchStmt":     "switch statements",
+	"*ast.TypeAssertExpr": "type assertions",
+	"*ast.TypeSwitchStmt": "type switches",
+	"*ast.UnaryExpr":      "channel receives",
+}
+
+// SummarizeUnsupported counts the given errors by the type of the AST node
+// they were reported for.
+func SummarizeUnsupported(errs []TranspileError) map[string]int {
+	summary := map[string]int{}
+	for _, e := range errs {
+		summary[fmt.Sprintf("%T", e.Node)]++
+	}
+	return summary
+}
+
+// DescribeNode returns a human readable description of an AST node type
+// name as returned by SummarizeUnsupported, or "" if there is none.
+func DescribeNode(name string) string {
+	return nodeDescriptions[name]
+}
@@ -0,0 +1,186 @@
+package transpiler
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+const unsupportedConstructs = `package main
+func loop() {
+	for i := range pins {
+		digitalWrite(i, HIGH)
+	}
+	go blink()
+	for _, p := range pins {
+		digitalWrite(p, LOW)
+	}
+	delay(1000)
+}
+`
+
+func TestSkipUnsupported(t *testing.T) {
+	var out bytes.Buffer
+	err := Transpile(&out, strings.NewReader(unsupportedConstructs), &Options{SkipUnsupported: true})
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList, got %v", err)
+	}
+	if len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %d: %v", len(errs), errs)
+	}
+	if !strings.Contains(out.String(), "delay(1000);") {
+		t.Errorf("expected the supported statements to be transpiled, got:\n%s", out.String())
+	}
+	if err := Transpile(&out, strings.NewReader(unsupportedConstructs), nil); err == nil {
+		t.Errorf("expected an error without SkipUnsupported")
+	}
+}
+
+func TestSummarizeUnsupported(t *testing.T) {
+	err := Transpile(&bytes.Buffer{}, strings.NewReader(unsupportedConstructs), &Options{SkipUnsupported: true})
+	errs, _ := err.(ErrorList)
+	summary := SummarizeUnsupported(errs)
+	expected := map[string]int{
+		"*ast.RangeStmt": 2,
+		"*ast.GoStmt":    1,
+	}
+	if len(summary) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, summary)
+	}
+	for n, c := range expected {
+		if summary[n] != c {
+			t.Errorf("expected %d occurrences of %s, got %d", c, n, summary[n])
+		}
+	}
+	if d := DescribeNode("*ast.RangeStmt"); d != "for-range loops" {
+		t.Errorf("unexpected description of *ast.RangeStmt: %q", d)
+	}
+}
+
+func TestSourceContext(t *testing.T) {
+	const src = `package main
+
+func setup() {
+	pinMode(13, OUTPUT)
+	go blink()
+	delay(1)
+}
+`
+	err := Transpile(io.Discard, strings.NewReader(src), nil)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	const context = `   3 | func setup() {
+   4 | 	pinMode(13, OUTPUT)
+   5 | 	go blink()
+     | 	^
+   6 | 	delay(1)
+   7 | }
+`
+	if !strings.Contains(err.Error(), "error handling decl at line 5: ") || !strings.HasSuffix(err.Error(), context) {
+		t.Errorf("expected the source context in:\n%v", err)
+	}
+}
+
+const erroneousDecls = `package main
+
+var a, b int
+
+func blink(c chan int) {
+}
+
+func toggle() {
+	go blink(nil)
+	delay(1000)
+}
+
+func loop() {
+	blink(nil)
+}
+`
+
+func TestBatchErrors(t *testing.T) {
+	var out bytes.Buffer
+	err := Transpile(&out, strings.NewReader(erroneousDecls), &Options{BatchErrors: true})
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList, got %v", err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %d: %v", len(errs), errs)
+	}
+	for i, line := range []int{3, 5, 9} {
+		if errs[i].Pos.Line != line {
+			t.Errorf("expected error %d at line %d, got %d: %v", i, line, errs[i].Pos.Line, errs[i])
+		}
+	}
+	if !strings.Contains(out.String(), "void loop() {") {
+		t.Errorf("expected the declarations following errors to be transpiled, got:\n%s", out.String())
+	}
+	if err := Transpile(&out, strings.NewReader(erroneousDecls), &Options{SkipUnsupported: true}); err == nil {
+		t.Errorf("expected an error with SkipUnsupported")
+	}
+}
+
+func TestBatchErrorsOnce(t *testing.T) {
+	for _, test := range []struct {
+		value, err string
+	}{
+		// The type of x is looked for before its value is handled.
+		{"[]map[int]int{}", "map type map[int]int is unsupported (line 4)"},
+		// Nor is anything recorded while looking.
+		{`map[string]int{"a": 1}`, "map type map[string]int is unsupported (line 4)"},
+	} {
+		src := "package main\n\nfunc loop() {\n\tx := " + test.value + "\n}\n"
+		err := Transpile(io.Discard, strings.NewReader(src), &Options{BatchErrors: true})
+		errs, ok := err.(ErrorList)
+		if !ok {
+			t.Fatalf("expected an ErrorList, got %v", err)
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Msg, test.err) {
+			t.Errorf("expected the single error %q for %s, got %v", test.err, test.value, errs)
+		}
+	}
+}
+
+const malformed = `package main
+
+123
+
+func loop() {
+	x := 1 + )
+	delay(x)
+}
+`
+
+func TestSyntaxErrors(t *testing.T) {
+	var out bytes.Buffer
+	err := Transpile(&out, strings.NewReader(malformed), &Options{BatchErrors: true})
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList, got %v", err)
+	}
+	want := `the Go parser encountered a syntax error at "123" (line 3); the original parsing error was: sketch.go:3:1: expected declaration, found 123`
+	if len(errs) == 0 || errs[0].Msg != want || errs[0].Pos.Line != 3 {
+		t.Errorf("expected the error %q at line 3 first, got %v", want, errs)
+	}
+
+	const loop = `package main
+
+func loop() {
+	x := 1 + )
+	delay(x)
+}
+`
+	err = Transpile(&out, strings.NewReader(loop), &Options{BatchErrors: true})
+	want = `the Go parser encountered a syntax error at ")" (line 4); the original parsing error was: sketch.go:4:11: expected operand, found ')'`
+	if errs, ok := err.(ErrorList); !ok || len(errs) == 0 || errs[0].Msg != want {
+		t.Errorf("expected the error %q first, got %v", want, err)
+	}
+
+	if err := Transpile(&out, strings.NewReader(malformed), nil); err == nil || !strings.Contains(err.Error(), "failed to parse file") {
+		t.Errorf("expected a parse error without BatchErrors, got %v", err)
+	}
+}
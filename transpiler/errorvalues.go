@@ -0,0 +1,127 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// errorCode is the value of the errors created by fmt.Errorf when they are
+// integers.
+const errorCode = 1
+
+// errorType returns the C++ type of error values selected by
+// Options.ErrorType.
+func errorType(out *output) string {
+	if out.opts.ErrorType == "" {
+		return "int"
+	}
+	return out.opts.ErrorType
+}
+
+// isErrorf reports whether c calls fmt.Errorf.
+func isErrorf(c *ast.CallExpr) bool {
+	sel, ok := c.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Errorf" {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Obj == nil && x.Name == "fmt"
+}
+
+// handleErrorf emits the error created by the call c to fmt.Errorf, which
+// is its format when errors are strings and errorCode otherwise. The other
+// arguments are not formatted, nor evaluated.
+func handleErrorf(out *output, c *ast.CallExpr) error {
+	if errorType(out) == "int" {
+		fmt.Fprint(out, errorCode)
+		return nil
+	}
+	if len(c.Args) == 0 {
+		return fmt.Errorf("missing format of fmt.Errorf (line %d)", out.line(c))
+	}
+	if err := handleExpr(out, c.Args[0]); err != nil {
+		return fmt.Errorf("error handling format of fmt.Errorf: %v", err)
+	}
+	return nil
+}
+
+// isError reports whether e is the predeclared error type.
+func isError(e ast.Expr) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == "error" && id.Obj == nil
+}
+
+// isNil reports whether e is the predeclared nil.
+func isNil(e ast.Expr) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == "nil" && id.Obj == nil
+}
+
+// cErrorType returns the C++ type of error values, which the type maps
+// take precedence for.
+func cErrorType(out *output) string {
+	t, err := exprTypeToType(out, ast.NewIdent("error"))
+	if err != nil {
+		return errorType(out)
+	}
+	return t
+}
+
+// nilError returns the C++ value of nil errors: NULL for pointers, 0
+// otherwise.
+func nilError(out *output) string {
+	if strings.HasSuffix(cErrorType(out), "*") {
+		return "NULL"
+	}
+	return "0"
+}
+
+// compareError returns be, a comparison, with nil replaced by the nil error
+// value if the other operand is an error.
+func compareError(out *output, be *ast.BinaryExpr) *ast.BinaryExpr {
+	cmp := *be
+	switch {
+	case isNil(be.Y) && typeFromExpr(out, be.X) == cErrorType(out):
+		cmp.Y = ast.NewIdent(nilError(out))
+	case isNil(be.X) && typeFromExpr(out, be.Y) == cErrorType(out):
+		cmp.X = ast.NewIdent(nilError(out))
+	default:
+		return be
+	}
+	return &cmp
+}
+
+// errorValue returns e, a value of the C++ type typ, replaced by the nil
+// error value if it is nil and typ is that of errors.
+func errorValue(out *output, typ string, e ast.Expr) ast.Expr {
+	if isNil(e) && typ == cErrorType(out) {
+		return ast.NewIdent(nilError(out))
+	}
+	return e
+}
+
+// returnValue returns e, the i-th value returned by the current function,
+// with nil errors replaced by their value.
+func returnValue(out *output, i int, e ast.Expr) ast.Expr {
+	if i >= len(out.returns) {
+		return e
+	}
+	return errorValue(out, out.returns[i], e)
+}
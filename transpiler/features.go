@@ -0,0 +1,63 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import "strings"
+
+// featureSet lists the language features the output may use.
+type featureSet struct {
+	// cpp is set for C++ and unset for C.
+	cpp bool
+	// lineComments allows // comments; block comments are used otherwise.
+	lineComments bool
+	// boolType is set when bool is a keyword rather than a macro of
+	// stdbool.h.
+	boolType bool
+	// auto allows variables declared with the type of their initializer.
+	auto bool
+	// braceInit allows braced initializer lists outside of declarations,
+	// as in return {a, b}.
+	braceInit bool
+	// alignas allows alignas and alignof.
+	alignas bool
+}
+
+// stdFeatures returns the features available with the given standard, and
+// false if it is unknown. The empty standard is C++11.
+func stdFeatures(std string) (featureSet, bool) {
+	cpp03 := featureSet{cpp: true, lineComments: true, boolType: true}
+	cpp11 := cpp03
+	cpp11.auto, cpp11.braceInit, cpp11.alignas = true, true, true
+	switch std {
+	case "c99":
+		return featureSet{}, true
+	case "c++03":
+		return cpp03, true
+	case "", "c++11", "c++14", "c++17":
+		return cpp11, true
+	default:
+		return featureSet{}, false
+	}
+}
+
+// comment returns the Go comment text as a C or C++ comment.
+func (o *output) comment(text string) string {
+	if o.features.lineComments || !strings.HasPrefix(text, "//") {
+		return text
+	}
+	return "/*" + strings.Replace(text[2:], "*/", "* /", -1) + " */"
+}
@@ -0,0 +1,47 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"bytes"
+	"log"
+	"os/exec"
+)
+
+// formatCommand is the command the output is piped through when
+// Options.Format is set.
+var formatCommand = []string{"clang-format", "--style=Google"}
+
+// formatCpp returns src formatted by formatCommand, or src itself if the
+// command is not available or fails.
+func formatCpp(src []byte) []byte {
+	path, err := exec.LookPath(formatCommand[0])
+	if err != nil {
+		log.Printf("warning: %s not found, the output is not formatted", formatCommand[0])
+		return src
+	}
+	cmd := exec.Command(path, formatCommand[1:]...)
+	cmd.Stdin = bytes.NewReader(src)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	formatted, err := cmd.Output()
+	if err != nil {
+		log.Printf("warning: %s failed, the output is not formatted: %v: %s", formatCommand[0], err, stderr.String())
+		return src
+	}
+	return formatted
+}
@@ -0,0 +1,48 @@
+package transpiler
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const unformatted = "package main\n\nfunc loop() {\n\tdelay(1 + 2)\n}\n"
+
+func TestFormat(t *testing.T) {
+	defer func(cmd []string) { formatCommand = cmd }(formatCommand)
+
+	formatCommand = []string{"tr", "a-z", "A-Z"}
+	if _, err := exec.LookPath("tr"); err != nil {
+		t.Skip("tr is not available")
+	}
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(unformatted), &Options{Format: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if want := "VOID LOOP() {\n  DELAY(1+2);\n}\n"; out.String() != want {
+		t.Errorf("expected the formatter to be invoked, got %q", out.String())
+	}
+
+	formatCommand = []string{"mugo-no-such-formatter"}
+	out.Reset()
+	if err := Transpile(&out, strings.NewReader(unformatted), &Options{Format: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if want := "void loop() {\n  delay(1+2);\n}\n"; out.String() != want {
+		t.Errorf("expected the unformatted output, got %q", out.String())
+	}
+}
+
+func TestClangFormat(t *testing.T) {
+	if _, err := exec.LookPath("clang-format"); err != nil {
+		t.Skip("clang-format is not available")
+	}
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(unformatted), &Options{Format: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if got := formatCpp(out.Bytes()); !bytes.Equal(got, out.Bytes()) {
+		t.Errorf("formatted output is not stable:\n%s-- reformatted:\n%s", out.Bytes(), got)
+	}
+}
@@ -0,0 +1,43 @@
+package transpiler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzTranspile checks that Transpile neither panics nor depends on anything
+// but its input, starting from the Go files of the golden tests.
+func FuzzTranspile(f *testing.F) {
+	for _, pattern := range []string{sketchDir + "/*/*.go", testDir + "/*/*.go"} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, p := range paths {
+			src, err := os.ReadFile(p)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(src)
+		}
+	}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		var out bytes.Buffer
+		file, err := TranspileBytes(&out, src, nil)
+		if err != nil {
+			return
+		}
+		if len(file.Decls) > 0 && out.Len() == 0 {
+			t.Errorf("no output for %d declarations", len(file.Decls))
+		}
+		var again bytes.Buffer
+		if _, err := TranspileBytes(&again, src, nil); err != nil {
+			t.Fatalf("failed to transpile again: %v", err)
+		}
+		if !bytes.Equal(out.Bytes(), again.Bytes()) {
+			t.Errorf("output differs between runs:\n%s-- then:\n%s", out.Bytes(), again.Bytes())
+		}
+	})
+}
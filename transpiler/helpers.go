@@ -0,0 +1,53 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// strcatHelper concatenates two strings into newly allocated memory, which
+// is never freed.
+const strcatHelper = "__mugo_strcat"
+
+// helperCode holds the definition of the helper functions the output may
+// use.
+var helperCode = map[string]string{
+	strcatHelper: `#include <stdlib.h>
+#include <string.h>
+const char * __mugo_strcat(const char * a, const char * b) {
+%[1]schar * s = (char *)malloc(strlen(a) + strlen(b) + 1);
+%[1]sstrcpy(s, a);
+%[1]sstrcat(s, b);
+%[1]sreturn s;
+}
+`,
+}
+
+// writeHelpers writes the definition of the helpers used by out to w.
+func writeHelpers(w io.Writer, out *output) {
+	names := []string{}
+	for n := range out.helpers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(w, helperCode[n], out.indentStr)
+	}
+}
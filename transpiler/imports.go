@@ -0,0 +1,203 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"go/ast"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// include is the C++ header a Go package stands for.
+type include struct {
+	header string
+	// flat is set when the header declares its names in the global
+	// namespace, in which case references to the package drop its name.
+	flat bool
+	// local is set for packages of the module transpiled in the same
+	// output, whose names are referred to as they are declared.
+	local bool
+	// namespace is the C++ namespace the names of the package are declared
+	// in, if not flat.
+	namespace string
+	// lowered is set for packages without a header, whose supported calls
+	// are transpiled one by one.
+	lowered bool
+}
+
+// importMap maps the last element of Go import paths to the header they
+// stand for.
+var importMap = map[string]include{
+	"arduino": {header: "Arduino.h", flat: true},
+	// Printing with fmt and log writes to Serial, see handlePrint, and
+	// fmt.Errorf creates errors, see handleErrorf.
+	"fmt": {lowered: true},
+	"log": {lowered: true},
+	// os is only imported to print to os.Stdout.
+	"os": {lowered: true},
+}
+
+// collectImports records the packages imported by f under the name they are
+// referred to by.
+func collectImports(out *output, f *ast.File) {
+	for _, is := range f.Imports {
+		p, err := strconv.Unquote(is.Path.Value)
+		if err != nil {
+			continue
+		}
+		inc, ok := importMap[path.Base(p)]
+		if isLocalImport(out, p) {
+			inc, ok = include{flat: true, local: true}, true
+		}
+		if !ok && out.opts.Namespaces {
+			inc, ok = namespaceInclude(p), true
+		}
+		if !ok {
+			continue
+		}
+		name := path.Base(p)
+		if is.Name != nil {
+			name = is.Name.Name
+		}
+		if name == "_" {
+			continue
+		}
+		out.imports[name] = inc
+	}
+	collectIncludes(out, f)
+}
+
+// collectIncludes records the headers requested with //mugo:c_include
+// annotations outside of function bodies, in order and without duplicates.
+// Headers without delimiters are taken to be system headers.
+func collectIncludes(out *output, f *ast.File) {
+	seen := map[string]bool{}
+	for _, h := range out.includes {
+		// Requested by another file of the package.
+		seen[h] = true
+	}
+	for _, cg := range f.Comments {
+		if insideBody(f, cg) {
+			continue
+		}
+		for _, h := range annotations(cg, "c_include") {
+			if !strings.HasPrefix(h, "<") && !strings.HasPrefix(h, `"`) {
+				h = "<" + h + ">"
+			}
+			if h != "<>" && !seen[h] {
+				seen[h] = true
+				out.includes = append(out.includes, h)
+			}
+		}
+	}
+}
+
+// insideBody reports whether n is located within the body of one of the
+// functions of f.
+func insideBody(f *ast.File, n ast.Node) bool {
+	for _, d := range f.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fd.Body != nil && n.Pos() >= fd.Body.Pos() && n.End() <= fd.Body.End() {
+			return true
+		}
+	}
+	return false
+}
+
+func handleImportSpec(out *output, is *ast.ImportSpec) error {
+	p, err := strconv.Unquote(is.Path.Value)
+	if err != nil {
+		return fmt.Errorf("invalid import path %s: %v", is.Path.Value, err)
+	}
+	if isLocalImport(out, p) {
+		// The package precedes this one in the output.
+		return nil
+	}
+	inc, ok := importMap[path.Base(p)]
+	if !ok && out.opts.Namespaces {
+		inc, ok = namespaceInclude(p), true
+	}
+	if !ok && is.Name != nil && is.Name.Name == "_" {
+		// Blank imports are only used for their side effects.
+		fmt.Fprintln(out, out.comment(fmt.Sprintf("// NOTE: blank import %q has no effect in mugo", p)))
+		return nil
+	}
+	if !ok {
+		return unsupported(out, is, "unsupported import %q (line %d)", p, out.line(is))
+	}
+	if inc.lowered {
+		return nil
+	}
+	if !out.headers[inc.header] {
+		// Other files of the package may import it too.
+		out.headers[inc.header] = true
+		fmt.Fprintf(out, "#include <%s>\n", inc.header)
+	}
+	return nil
+}
+
+// importedName returns the C++ name of se if it refers to a name exported
+// by a package mapped to a flat header or to a namespace.
+func importedName(out *output, se *ast.SelectorExpr) (string, bool) {
+	x, ok := se.X.(*ast.Ident)
+	if !ok || x.Obj != nil {
+		return "", false
+	}
+	inc, ok := out.imports[x.Name]
+	switch {
+	case !ok:
+		return "", false
+	case inc.namespace != "":
+		return inc.namespace + "::" + se.Sel.Name, true
+	case !inc.flat:
+		return "", false
+	case inc.local:
+		return se.Sel.Name, true
+	}
+	return flatName(se.Sel.Name), true
+}
+
+// namespaceInclude returns the include of the package imported as p with
+// Options.Namespaces.
+func namespaceInclude(p string) include {
+	return include{header: path.Base(p) + ".h", namespace: path.Base(p)}
+}
+
+// isLocalImport reports whether the package imported as p is transpiled in
+// the same output.
+func isLocalImport(out *output, p string) bool {
+	for _, l := range out.opts.LocalImports {
+		if l == p {
+			return true
+		}
+	}
+	return false
+}
+
+// flatName returns the C++ name of the exported Go name n. Names in upper
+// case, such as HIGH or LED_BUILTIN, are kept as is while others start with
+// a lower case letter, turning DigitalWrite into digitalWrite.
+func flatName(n string) string {
+	if strings.ToUpper(n) == n {
+		return n
+	}
+	r, size := utf8.DecodeRuneInString(n)
+	return string(unicode.ToLower(r)) + n[size:]
+}
@@ -0,0 +1,79 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// Lint describes a Go construct which works on the MCU but is expensive in
+// code size or memory.
+type Lint struct {
+	Pos      token.Pos
+	Severity Severity
+	Message  string
+}
+
+// expensiveCalls maps package level functions to the reason they should be
+// avoided on the MCU.
+var expensiveCalls = map[string]string{
+	"fmt.Sprintf":  "fmt.Sprintf adds a large formatting library to the binary",
+	"strings.Join": "strings.Join allocates memory",
+	"sort.Slice":   "sort.Slice relies on a function literal and reflection",
+}
+
+// LintForMCU reports the constructs of f which are unsuitable for an MCU.
+func LintForMCU(f *ast.File) []Lint {
+	var lints []Lint
+	inner := map[ast.Node]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.CallExpr:
+			sel, ok := node.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			pkg, ok := sel.X.(*ast.Ident)
+			if !ok || pkg.Obj != nil {
+				return true
+			}
+			if msg, ok := expensiveCalls[pkg.Name+"."+sel.Sel.Name]; ok {
+				lints = append(lints, Lint{node.Pos(), Warning, msg})
+			}
+		case *ast.BinaryExpr:
+			if inner[node] || !isStringConcat(node) {
+				return true
+			}
+			inner[node.X] = true
+			inner[node.Y] = true
+			lints = append(lints, Lint{node.Pos(), Warning, "string concatenation allocates memory"})
+		case *ast.FuncType:
+			for _, p := range node.Params.List {
+				t := p.Type
+				if e, ok := t.(*ast.Ellipsis); ok {
+					t = e.Elt
+				}
+				if it, ok := t.(*ast.InterfaceType); ok && len(it.Methods.List) == 0 {
+					lints = append(lints, Lint{p.Pos(), Info, "interface{} parameter requires boxing its arguments"})
+				}
+			}
+		}
+		return true
+	})
+	return lints
+}
@@ -0,0 +1,59 @@
+package transpiler
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+const expensive = `package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func log(args ...interface{}) {
+}
+
+func report(values []int, names []string) {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	msg := fmt.Sprintf("%d values", len(values))
+	all := strings.Join(names, ", ")
+	log(msg + ": " + all)
+}
+
+func store(v interface{}, s fmt.Stringer) {
+}
+`
+
+func TestLintForMCU(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "sketch.go", expensive, 0)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	expected := []struct {
+		line     int
+		severity Severity
+		message  string
+	}{
+		{9, Info, "interface{} parameter requires boxing its arguments"},
+		{13, Warning, "sort.Slice relies on a function literal and reflection"},
+		{14, Warning, "fmt.Sprintf adds a large formatting library to the binary"},
+		{15, Warning, "strings.Join allocates memory"},
+		{16, Warning, "string concatenation allocates memory"},
+		{19, Info, "interface{} parameter requires boxing its arguments"},
+	}
+	lints := LintForMCU(f)
+	if len(lints) != len(expected) {
+		t.Fatalf("expected %d lints, got %d: %v", len(expected), len(lints), lints)
+	}
+	for i, e := range expected {
+		l := lints[i]
+		if line := fset.Position(l.Pos).Line; line != e.line || l.Severity != e.severity || l.Message != e.message {
+			t.Errorf("expected %d: %v: %s, got %d: %v: %s", e.line, e.severity, e.message, line, l.Severity, l.Message)
+		}
+	}
+}
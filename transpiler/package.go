@@ -0,0 +1,167 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ResolveImports returns the directory of the package imported as
+// importPath, which must belong to the module described by the go.mod file
+// at goModPath.
+func ResolveImports(goModPath string, importPath string) (string, error) {
+	mod, err := modulePath(goModPath)
+	if err != nil {
+		return "", err
+	}
+	rel := strings.TrimPrefix(importPath, mod)
+	if rel != "" && (rel == importPath || rel[0] != '/') {
+		return "", fmt.Errorf("package %q is not in module %q", importPath, mod)
+	}
+	return filepath.Join(filepath.Dir(goModPath), filepath.FromSlash(rel)), nil
+}
+
+// modulePath returns the path declared by the module directive of the
+// go.mod file at goModPath.
+func modulePath(goModPath string) (string, error) {
+	content, err := os.ReadFile(goModPath)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		mod := fields[1]
+		if strings.HasPrefix(mod, `"`) || strings.HasPrefix(mod, "`") {
+			if mod, err = strconv.Unquote(mod); err != nil {
+				return "", fmt.Errorf("invalid module path %s in %s", fields[1], goModPath)
+			}
+		}
+		return mod, nil
+	}
+	return "", fmt.Errorf("no module directive in %s", goModPath)
+}
+
+// findGoMod returns the path of the go.mod file of the module dir belongs
+// to.
+func findGoMod(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for d := abs; ; d = filepath.Dir(d) {
+		p := filepath.Join(d, "go.mod")
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+		if filepath.Dir(d) == d {
+			return "", fmt.Errorf("no go.mod found for %s", dir)
+		}
+	}
+}
+
+// TranspilePackage transpiles the package in dir, preceded by the packages
+// of its module it imports, found with the go.mod file of the module. All
+// the files are transpiled together, so the helpers they need are emitted
+// once, and the names of all the packages share a single C++ namespace.
+func TranspilePackage(dir string, out io.Writer, opts *Options) error {
+	goMod, err := findGoMod(dir)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	var p packageSources
+	if err := p.add(goMod, dir, map[string]bool{}); err != nil {
+		return err
+	}
+	o := *opts
+	o.LocalImports = p.local
+	_, err = transpileFiles(out, p.srcs, &o)
+	return err
+}
+
+// packageSources collects the files of a package and of the local packages
+// it imports.
+type packageSources struct {
+	// srcs lists the files, those of imported packages first.
+	srcs []source
+	// local lists the import paths of the local packages.
+	local []string
+}
+
+// add collects the files of the package in dir after those of the local
+// packages it imports, skipping the packages in done.
+func (p *packageSources) add(goMod, dir string, done map[string]bool) error {
+	if done[dir] {
+		return nil
+	}
+	done[dir] = true
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return err
+	}
+	var files []source
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := parser.ParseFile(fset, path, src, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		for _, is := range f.Imports {
+			ip, err := strconv.Unquote(is.Path.Value)
+			if err != nil {
+				return fmt.Errorf("invalid import path %s in %s", is.Path.Value, path)
+			}
+			d, err := ResolveImports(goMod, ip)
+			if err != nil {
+				// Not a package of the module.
+				continue
+			}
+			if err := p.add(goMod, d, done); err != nil {
+				return fmt.Errorf("error transpiling %s imported by %s: %v", ip, path, err)
+			}
+			p.local = append(p.local, ip)
+		}
+		files = append(files, source{path, src, dir})
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no Go files in %s", dir)
+	}
+	p.srcs = append(p.srcs, files...)
+	return nil
+}
@@ -0,0 +1,287 @@
+package transpiler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// module is a minimal module with a sketch importing a package of its own.
+var module = map[string]string{
+	"go.mod": `module example.com/blinky
+
+go 1.16
+`,
+	"blinky.go": `package main
+
+import "example.com/blinky/led"
+
+func setup() {
+	led.Init(13)
+}
+
+func loop() {
+	led.Toggle()
+	delay(500)
+}
+`,
+	"led/led.go": `package led
+
+var pin int
+var on bool
+
+func Init(p int) {
+	pin = p
+	pinMode(pin, OUTPUT)
+}
+
+func Toggle() {
+	on = !on
+	if on {
+		digitalWrite(pin, HIGH)
+	} else {
+		digitalWrite(pin, LOW)
+	}
+}
+`,
+}
+
+func writeModule(t *testing.T) string {
+	dir := t.TempDir()
+	for name, content := range module {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestResolveImports(t *testing.T) {
+	dir := writeModule(t)
+	goMod := filepath.Join(dir, "go.mod")
+	for _, test := range []struct {
+		path, want string
+	}{
+		{"example.com/blinky", dir},
+		{"example.com/blinky/led", filepath.Join(dir, "led")},
+		{"example.com/blinkyled", ""},
+		{"github.com/googlesamples/mugo/sketches/arduino", ""},
+	} {
+		got, err := ResolveImports(goMod, test.path)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("expected an error resolving %s, got %s", test.path, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("expected %s to resolve to %s, got %s, %v", test.path, test.want, got, err)
+		}
+	}
+	if _, err := ResolveImports(filepath.Join(dir, "led", "go.mod"), "example.com/blinky"); err == nil {
+		t.Errorf("expected an error for a missing go.mod")
+	}
+}
+
+func TestTranspilePackage(t *testing.T) {
+	dir := writeModule(t)
+	const want = `int pin = 0;
+bool on = false;
+void Init(int p) {
+  pin=p;
+  pinMode(pin, OUTPUT);
+}
+void Toggle() {
+  on=!on;
+  if (on) {
+  digitalWrite(pin, HIGH);
+} else {
+  digitalWrite(pin, LOW);
+}
+}
+void setup() {
+  Init(13);
+}
+void loop() {
+  Toggle();
+  delay(500);
+}
+`
+	var out bytes.Buffer
+	if err := TranspilePackage(dir, &out, nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestTranspilePackageFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod": "module example.com/cursor\n",
+		"a.go": `package main
+
+type Point struct {
+	X, Y int
+}
+
+var origin = new(Point)
+
+func label(name string) string {
+	return "a:" + name
+}
+
+func setup() {
+	pinMode(origin.X, OUTPUT)
+}
+`,
+		"b.go": `package main
+
+var cursor = new(Point)
+
+func tag(name string) string {
+	return "b:" + name
+}
+
+func loop() {
+	cursor.X = len(label("x")) + len(tag("y"))
+}
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The helpers and temporaries of both files are shared.
+	const want = `#include <stdlib.h>
+#include <string.h>
+const char * __mugo_strcat(const char * a, const char * b) {
+  char * s = (char *)malloc(strlen(a) + strlen(b) + 1);
+  strcpy(s, a);
+  strcat(s, b);
+  return s;
+}
+struct Point {
+  int X;
+  int Y;
+};
+static Point __mugo_new0;
+Point* origin = &__mugo_new0;
+const char * label(const char * name) {
+  return __mugo_strcat("a:", name);
+}
+void setup() {
+  pinMode(origin->X, OUTPUT);
+}
+static Point __mugo_new1;
+Point* cursor = &__mugo_new1;
+const char * tag(const char * name) {
+  return __mugo_strcat("b:", name);
+}
+void loop() {
+  cursor->X=strlen(label("x"))+strlen(tag("y"));
+}
+`
+	var out bytes.Buffer
+	if err := TranspilePackage(dir, &out, nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+	if msg, err := checkSyntax(t, "c++11", out.String()); err != nil {
+		t.Errorf("failed to compile the output: %v\n%s", err, msg)
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod": "module example.com/station\n",
+		"station.go": `package main
+
+import "example.com/station/sensor"
+
+func loop() {
+	r := sensor.Read(0)
+	v := r.Value
+	delay(v)
+}
+`,
+		"sensor/sensor.go": `package sensor
+
+type Reading struct {
+	Value int
+}
+
+func Read(pin int) Reading {
+	return Reading{analogRead(pin)}
+}
+`,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := TranspilePackage(dir, &bytes.Buffer{}, nil); err == nil || !strings.Contains(err.Error(), "cannot infer the type of r") {
+		t.Errorf("expected the type of r to be unknown without TypeCheck, got %v", err)
+	}
+	const want = `struct Reading {
+  int Value;
+};
+Reading Read(int pin) {
+  return {analogRead(pin)};
+}
+void loop() {
+  Reading r = Read(0);
+  int v = r.Value;
+  delay(v);
+}
+`
+	var out bytes.Buffer
+	if err := TranspilePackage(dir, &out, &Options{TypeCheck: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestTypeCheckImportError(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":           "module example.com/station\n",
+		"sensor/sensor.go": "package sensor\n\nfunc Read(pin int) int {\n\treturn\n",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const src = `package main
+
+import "example.com/station/sensor"
+
+func loop() {
+	delay(sensor.Read(0))
+}
+`
+	err := Transpile(&bytes.Buffer{}, strings.NewReader(src), &Options{TypeCheck: true, Dir: dir})
+	if err == nil || !strings.Contains(err.Error(), "failed to import example.com/station/sensor") {
+		t.Errorf("expected an error importing the sensor package, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"regexp"
+	"strings"
+)
+
+// operand matches a variable or a field of one.
+var operand = regexp.MustCompile(`^[A-Za-z_]\w*((\.|->)[A-Za-z_]\w*)*$`)
+
+// peepholeOptimize returns the lines of C++ code without the statements
+// which have no effect: assignments of a variable to itself, such as x=x;,
+// and discarded zeros, (void)0;. Declarations such as the int x = x; a
+// shadowing x := x turns into are kept, as they declare a new variable.
+// The lines are matched one at a time as text, so only statements on a
+// line of their own are removed.
+func peepholeOptimize(lines []string) []string {
+	kept := []string{}
+	for _, l := range lines {
+		if !redundant(strings.TrimSpace(l)) {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// redundant reports whether the statement s has no effect.
+func redundant(s string) bool {
+	switch s {
+	case "(void)0;", "(void)(0);":
+		return true
+	}
+	if !strings.HasSuffix(s, ";") {
+		return false
+	}
+	s = strings.TrimSuffix(s, ";")
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 || s[i+1] == '=' || strings.ContainsRune("=!<>+-*/%&|^", rune(s[i-1])) {
+		// Not an assignment.
+		return false
+	}
+	lhs, rhs := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	return operand.MatchString(rhs) && lhs == rhs
+}
@@ -0,0 +1,70 @@
+package transpiler
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPeepholeOptimize(t *testing.T) {
+	lines := []string{
+		"x=x;",
+		"  int x = x;",
+		"  const char * s = s;",
+		"  p->next = p->next;",
+		"(void)0;",
+		"  (void)(0);",
+		"x = y;",
+		"x += x;",
+		"x == x;",
+		"*p = p;",
+		"p->x = x;",
+		"int* s = buf; int s_len = 0;",
+		`Serial.println("x = x;");`,
+	}
+	want := []string{
+		"  int x = x;",
+		"  const char * s = s;",
+		"x = y;",
+		"x += x;",
+		"x == x;",
+		"*p = p;",
+		"p->x = x;",
+		"int* s = buf; int s_len = 0;",
+		`Serial.println("x = x;");`,
+	}
+	if got := peepholeOptimize(lines); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestPeephole(t *testing.T) {
+	const src = `package main
+
+func loop() {
+	x := 1
+	x = x
+	_ = 0
+	if x > 0 {
+		x := x
+		delay(x)
+	}
+}
+`
+	const want = `void loop() {
+  int x = 1;
+  if (x>0) {
+  int x = x;
+  delay(x);
+}
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{Peephole: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
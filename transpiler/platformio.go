@@ -0,0 +1,61 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// GeneratePlatformIO creates in dir a PlatformIO project building the Go
+// source file in: a platformio.ini file for the board of opts.Target, the
+// AVR one if empty, and src/main.cpp holding the transpiled code. Unlike
+// sketches, PlatformIO sources include Arduino.h themselves.
+func GeneratePlatformIO(dir, in string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	name := opts.Target
+	if name == "" {
+		name = "avr"
+	}
+	target := getTargetConfig(name)
+	if target == nil || target.Platform == "" {
+		return fmt.Errorf("unknown PlatformIO target %q", opts.Target)
+	}
+	src, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	var code bytes.Buffer
+	if !includes(target, `"Arduino.h"`) {
+		fmt.Fprintln(&code, "#include <Arduino.h>")
+	}
+	if _, err := TranspileBytes(&code, src, opts); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+		return err
+	}
+	ini := fmt.Sprintf("[env:%[1]s]\nplatform = %[2]s\nboard = %[1]s\nframework = arduino\n", target.Board, target.Platform)
+	if err := os.WriteFile(filepath.Join(dir, "platformio.ini"), []byte(ini), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "src", "main.cpp"), code.Bytes(), 0644)
+}
@@ -0,0 +1,40 @@
+package transpiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeneratePlatformIO(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "blink.go")
+	if err := os.WriteFile(in, []byte("package main\n\nfunc loop() {\n\tdelay(1000)\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(dir, "blink")
+	if err := GeneratePlatformIO(project, in, &Options{Target: "avr"}); err != nil {
+		t.Fatalf("failed to generate the project: %v", err)
+	}
+	for file, want := range map[string]string{
+		"platformio.ini": "[env:uno]\nplatform = atmelavr\nboard = uno\nframework = arduino\n",
+		"src/main.cpp":   "#include <Arduino.h>\n#include <stdint.h>\nvoid loop() {\n  delay(1000);\n}\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(project, filepath.FromSlash(file)))
+		if err != nil {
+			t.Errorf("failed to read %s: %v", file, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("expected %s to be:\n%s-- got:\n%s", file, want, got)
+		}
+	}
+
+	if err := GeneratePlatformIO(project, in, &Options{Target: "esp32"}); err != nil {
+		t.Fatalf("failed to generate the project: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(project, "platformio.ini"))
+	if want := "[env:esp32dev]\nplatform = espressif32\nboard = esp32dev\nframework = arduino\n"; err != nil || string(got) != want {
+		t.Errorf("expected platformio.ini to be:\n%s-- got:\n%s (%v)", want, got, err)
+	}
+}
@@ -0,0 +1,133 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// printCall returns the function of the fmt or log package c prints with,
+// and the arguments it prints, if c prints to the standard output. Printing
+// to os.Stdout with fmt.Fprintf is the same as with fmt.Printf.
+func printCall(c *ast.CallExpr) (pkg, name string, args []ast.Expr, ok bool) {
+	sel, ok := c.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", "", nil, false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || x.Obj != nil {
+		return "", "", nil, false
+	}
+	switch x.Name + "." + sel.Sel.Name {
+	case "fmt.Print", "fmt.Println", "fmt.Printf", "log.Print", "log.Println", "log.Printf":
+		return x.Name, sel.Sel.Name, c.Args, true
+	case "fmt.Fprintf":
+		if len(c.Args) > 0 && types.ExprString(c.Args[0]) == "os.Stdout" {
+			return x.Name, "Printf", c.Args[1:], true
+		}
+	}
+	return "", "", nil, false
+}
+
+// isPrint reports whether c prints to the standard output with the fmt or
+// log package.
+func isPrint(c *ast.CallExpr) bool {
+	_, _, _, ok := printCall(c)
+	return ok
+}
+
+// handlePrint emits the printing of the call c to the fmt or log package as
+// calls to the methods of Serial, separated by commas. As in Go, Print
+// separates the operands which are not strings with spaces, Println all of
+// them, and log ends what it prints with a newline. Printf requires a Serial
+// with a printf method, as on the ESP32.
+func handlePrint(out *output, c *ast.CallExpr) error {
+	pkg, name, args, _ := printCall(c)
+	var calls []string
+	call := func(method string, args []ast.Expr) error {
+		values := []string{}
+		for _, a := range args {
+			var buf bytes.Buffer
+			if err := handleExpr(out.to(&buf), a); err != nil {
+				return fmt.Errorf("error handling printed value %v: %v", types.ExprString(a), err)
+			}
+			values = append(values, buf.String())
+		}
+		calls = append(calls, fmt.Sprintf("Serial.%s(%s)", method, strings.Join(values, ", ")))
+		return nil
+	}
+	newline := name == "Println" || pkg == "log"
+	switch name {
+	case "Printf":
+		if len(args) == 0 {
+			return fmt.Errorf("missing format of %s.Printf (line %d)", pkg, out.line(c))
+		}
+		if err := call("printf", args); err != nil {
+			return err
+		}
+		if newline && !endsWithNewline(args[0]) {
+			calls = append(calls, "Serial.println()")
+		}
+	default:
+		for i, a := range args {
+			if i > 0 && (name == "Println" || !isString(out, args[i-1]) && !isString(out, a)) {
+				calls = append(calls, `Serial.print(" ")`)
+			}
+			method := "print"
+			if newline && i == len(args)-1 {
+				method = "println"
+			}
+			if err := call(method, []ast.Expr{a}); err != nil {
+				return err
+			}
+		}
+		if newline && len(args) == 0 {
+			calls = append(calls, "Serial.println()")
+		}
+	}
+	if len(calls) == 0 {
+		// Nothing is printed.
+		calls = append(calls, "(void)0")
+	}
+	fmt.Fprint(out, strings.Join(calls, ", "))
+	return nil
+}
+
+// isString reports whether e is a string.
+func isString(out *output, e ast.Expr) bool {
+	if lit, ok := e.(*ast.BasicLit); ok {
+		return lit.Kind == token.STRING
+	}
+	return typeFromExpr(out, e) == basicTypes["string"]
+}
+
+// endsWithNewline reports whether e is a string literal ending with a
+// newline.
+func endsWithNewline(e ast.Expr) bool {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return err == nil && strings.HasSuffix(s, "\n")
+}
@@ -0,0 +1,138 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"go/ast"
+)
+
+// ReachableFunctions returns the names of the functions of f which may be
+// called, directly or not, from the given entry points. Methods are named
+// as in the output, Type_Method. As the type a method is called on is not
+// known, calling a method reaches all the methods of that name. init
+// functions, interrupt service routines and the functions used by package
+// level declarations are always reachable.
+func ReachableFunctions(f *ast.File, entryPoints []string) map[string]bool {
+	funcs := declaredFuncs(f)
+	roots := append([]string{"init"}, entryPoints...)
+	for _, d := range f.Decls {
+		switch decl := d.(type) {
+		case *ast.FuncDecl:
+			if _, ok := annotation(decl.Doc, "isr"); ok {
+				roots = append(roots, decl.Name.Name)
+			}
+		case *ast.GenDecl:
+			roots = append(roots, referencedNames(decl)...)
+		}
+	}
+
+	reachable := map[string]bool{}
+	seen := map[*ast.FuncDecl]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		for _, fd := range funcs[name] {
+			if seen[fd] {
+				continue
+			}
+			seen[fd] = true
+			reachable[outputName(fd)] = true
+			if fd.Body == nil {
+				continue
+			}
+			for _, ref := range referencedNames(fd.Body) {
+				visit(ref)
+			}
+		}
+	}
+	for _, name := range roots {
+		visit(name)
+	}
+	return reachable
+}
+
+// RecursiveFunctions returns the names of the functions of f which may call
+// themselves, directly or not, in the order they are declared. Methods are
+// named and calls to methods resolved as in ReachableFunctions.
+func RecursiveFunctions(f *ast.File) []string {
+	funcs := declaredFuncs(f)
+	var recursive []string
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		seen := map[*ast.FuncDecl]bool{}
+		var calls func(caller *ast.FuncDecl) bool
+		calls = func(caller *ast.FuncDecl) bool {
+			if caller.Body == nil {
+				return false
+			}
+			for _, ref := range referencedNames(caller.Body) {
+				for _, callee := range funcs[ref] {
+					if callee == fd {
+						return true
+					}
+					if !seen[callee] {
+						seen[callee] = true
+						if calls(callee) {
+							return true
+						}
+					}
+				}
+			}
+			return false
+		}
+		if calls(fd) {
+			recursive = append(recursive, outputName(fd))
+		}
+	}
+	return recursive
+}
+
+// declaredFuncs maps the Go names of the functions and methods of f to
+// their declarations.
+func declaredFuncs(f *ast.File) map[string][]*ast.FuncDecl {
+	funcs := map[string][]*ast.FuncDecl{}
+	for _, d := range f.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok {
+			funcs[fd.Name.Name] = append(funcs[fd.Name.Name], fd)
+		}
+	}
+	return funcs
+}
+
+// outputName returns the name of fd in the output, Type_Method for
+// methods.
+func outputName(fd *ast.FuncDecl) string {
+	if t, _, ok := receiverType(fd); ok {
+		return methodName(t, fd.Name.Name)
+	}
+	return fd.Name.Name
+}
+
+// referencedNames returns the names n refers to which may be functions or
+// methods, that is those which are not local variables or types.
+func referencedNames(n ast.Node) []string {
+	var names []string
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && (id.Obj == nil || id.Obj.Kind == ast.Fun) {
+			names = append(names, id.Name)
+		}
+		return true
+	})
+	return names
+}
@@ -0,0 +1,56 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+// supportedNodes describes the AST nodes handleDecl, handleStmt and
+// handleExpr transpile, at least in part. It must be updated along with
+// their cases.
+var supportedNodes = map[string]string{
+	"*ast.AssignStmt":   "assignments and short variable declarations",
+	"*ast.BasicLit":     "number, character and string literals",
+	"*ast.BinaryExpr":   "binary operators",
+	"*ast.CallExpr":     "function, method and builtin calls, and conversions",
+	"*ast.CompositeLit": "struct, array and slice literals",
+	"*ast.DeclStmt":     "local var and type declarations",
+	"*ast.EmptyStmt":    "empty statements",
+	"*ast.ExprStmt":     "expression statements",
+	"*ast.ForStmt":      "for loops",
+	"*ast.FuncDecl":     "functions and methods",
+	"*ast.FuncLit":      "function literals without captured variables",
+	"*ast.GenDecl":      "package level const, var, type and import declarations",
+	"*ast.Ident":        "identifiers",
+	"*ast.IfStmt":       "if statements",
+	"*ast.IncDecStmt":   "increments and decrements",
+	"*ast.IndexExpr":    "index expressions",
+	"*ast.ParenExpr":    "parenthesized expressions",
+	"*ast.RangeStmt":    "for-range loops over arrays",
+	"*ast.ReturnStmt":   "return statements",
+	"*ast.SelectorExpr": "field and package member selectors",
+	"*ast.SliceExpr":    "slices of whole arrays",
+	"*ast.StarExpr":     "pointer dereferences",
+	"*ast.UnaryExpr":    "unary operators other than channel receives",
+}
+
+// SupportedNodes returns the AST node type names, as returned by
+// SummarizeUnsupported, that are supported with their description.
+func SupportedNodes() map[string]string {
+	nodes := make(map[string]string, len(supportedNodes))
+	for n, d := range supportedNodes {
+		nodes[n] = d
+	}
+	return nodes
+}
@@ -0,0 +1,70 @@
+package transpiler
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestSupportedNodes(t *testing.T) {
+	nodes := SupportedNodes()
+	if len(nodes) == 0 {
+		t.Fatal("expected supported nodes")
+	}
+	for _, n := range []string{"*ast.IfStmt", "*ast.ReturnStmt", "*ast.AssignStmt", "*ast.CallExpr"} {
+		if nodes[n] == "" {
+			t.Errorf("expected %s to be supported", n)
+		}
+	}
+
+	// Every case of the handlers not only reporting the node as
+	// unsupported must be listed.
+	f, err := parser.ParseFile(token.NewFileSet(), "transpiler.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Name.Name != "handleDecl" && fd.Name.Name != "handleStmt" && fd.Name.Name != "handleExpr" {
+			continue
+		}
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			ts, ok := n.(*ast.TypeSwitchStmt)
+			if !ok {
+				return true
+			}
+			for _, s := range ts.Body.List {
+				cc := s.(*ast.CaseClause)
+				if reportsUnsupported(cc.Body) {
+					continue
+				}
+				for _, e := range cc.List {
+					if name := types.ExprString(e); nodes[name] == "" {
+						t.Errorf("%s handles %s, which is missing from supportedNodes", fd.Name.Name, name)
+					}
+				}
+			}
+			return false
+		})
+	}
+}
+
+// reportsUnsupported reports whether body only returns an unsupported or
+// syntax error.
+func reportsUnsupported(body []ast.Stmt) bool {
+	if len(body) != 1 {
+		return false
+	}
+	ret, ok := body[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return false
+	}
+	c, ok := ret.Results[0].(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	fun, ok := c.Fun.(*ast.Ident)
+	return ok && (fun.Name == "unsupported" || fun.Name == "syntaxError")
+}
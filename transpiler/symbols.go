@@ -0,0 +1,63 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"encoding/json"
+	"go/ast"
+	"io"
+)
+
+// Symbol locates the C++ function a Go function is emitted as.
+type Symbol struct {
+	// Name is the name of the C++ function.
+	Name string `json:"name"`
+	// File and Line are the position of the Go function.
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// goSymbol returns the name of fd as Go reports it in stack traces, such as
+// main.loop or main.(*led).on, in the package named pkg.
+func goSymbol(pkg string, fd *ast.FuncDecl) string {
+	t, pointer, ok := receiverType(fd)
+	switch {
+	case !ok:
+		return pkg + "." + fd.Name.Name
+	case pointer:
+		return pkg + ".(*" + t + ")." + fd.Name.Name
+	default:
+		return pkg + "." + t + "." + fd.Name.Name
+	}
+}
+
+// recordSymbol records in the symbol map, if requested, that fd is emitted
+// as the C++ function name.
+func recordSymbol(out *output, fd *ast.FuncDecl, name string) {
+	if out.symbolMap == nil {
+		return
+	}
+	pos := out.fset.Position(fd.Pos())
+	out.symbolMap[goSymbol(out.pkg, fd)] = Symbol{Name: name, File: pos.Filename, Line: pos.Line}
+}
+
+// writeSymbolMap writes the symbol map of out to w as a JSON object.
+func writeSymbolMap(w io.Writer, out *output) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out.symbolMap)
+}
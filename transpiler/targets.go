@@ -0,0 +1,62 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+// TargetConfig describes an MCU family the output can be compiled for.
+type TargetConfig struct {
+	// RAM is the size of the RAM in bytes.
+	RAM int
+	// IntWidth is the width of int in bits.
+	IntWidth int
+	// Includes are the headers the output starts with, with their
+	// delimiters.
+	Includes []string
+	// TypeMap maps Go types to target specific C++ types. Options.TypeMap
+	// takes precedence.
+	TypeMap map[string]string
+	// Platform and Board are the PlatformIO platform and default board of
+	// the target.
+	Platform, Board string
+}
+
+// targetConfig maps the supported targets to their configuration.
+var targetConfig = map[string]*TargetConfig{
+	"avr": {
+		RAM:      2048,
+		IntWidth: 16,
+		Includes: []string{"<stdint.h>"},
+		Platform: "atmelavr",
+		Board:    "uno",
+	},
+	"esp32": {
+		RAM:      320 * 1024,
+		IntWidth: 32,
+		Includes: []string{`"Arduino.h"`, "<stdint.h>"},
+		TypeMap:  map[string]string{"error": "esp_err_t"},
+		Platform: "espressif32",
+		Board:    "esp32dev",
+	},
+}
+
+// getTargetConfig returns the configuration of target, which is empty if
+// target is, or nil if target is unknown.
+func getTargetConfig(target string) *TargetConfig {
+	if target == "" {
+		return &TargetConfig{}
+	}
+	return targetConfig[target]
+}
@@ -22,152 +22,2528 @@ import (
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
+	"go/types"
 	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// Options configures a transpilation. The zero value is ready to use.
+type Options struct {
+	// Debug, if not nil, receives a dump of the parsed AST.
+	Debug io.Writer `json:"-"`
+	// SymbolMap, if not nil, receives a JSON object mapping the Go names of
+	// the functions, such as main.loop, to the Symbol they are emitted as.
+	SymbolMap io.Writer `json:"-"`
+	// InterfaceDispatch selects how method calls on interface values are
+	// emitted. With "static", the default, the concrete type stored in each
+	// interface variable is resolved at transpile time and methods are called
+	// directly. With "vtable", interface values hold a pointer to their
+	// concrete value and to a struct of function pointers to its methods,
+	// so that they may hold values of different types.
+	InterfaceDispatch string
+	// MaxCodeSize, if positive, is the flash size in bytes above which a
+	// warning is added to the output. See EstimateCodeSize.
+	MaxCodeSize int
+	// LargeStructThreshold is the size in bytes above which struct
+	// parameters are passed by const reference instead of being copied.
+	// Zero means 4 bytes, a negative value disables passing by reference.
+	LargeStructThreshold int
+	// TypeMap maps Go type names to the C++ type they stand for, typically
+	// one provided by an Arduino library. Declarations of mapped types are
+	// not emitted. Types of other packages are mapped by their qualified
+	// name, such as "io.Writer".
+	TypeMap map[string]string
+	// ErrorType is the C++ type error values are represented with: "int",
+	// the default, is zero for nil and a nonzero error code otherwise, and
+	// "const char*" is null for nil and the message otherwise.
+	ErrorType string
+	// Namespaces makes Transpile accept the imports of packages which are
+	// not known to stand for an Arduino header: package p is expected to be
+	// declared as namespace p of the header p.h, and its names are
+	// qualified with p::.
+	Namespaces bool
+	// SkipUnsupported makes Transpile leave unsupported statements and
+	// declarations out of the output instead of stopping at the first one.
+	// They are then returned as an ErrorList.
+	SkipUnsupported bool
+	// BatchErrors makes Transpile carry on past any error, not only
+	// unsupported constructs, so that all of them are reported at once as
+	// an ErrorList. This includes syntax errors, the rest of the file being
+	// transpiled as far as it could be parsed.
+	BatchErrors bool
+	// InlineInit makes Transpile copy the body of init functions at the
+	// start of setup instead of emitting them as functions called from
+	// there.
+	InlineInit bool
+	// UseHeap makes new allocate on the heap when there is no arena. By
+	// default, each call to new refers to a static variable of its own.
+	UseHeap bool
+	// GenerateDestructors gives the structs with pointer fields a
+	// destructor deleting what they point to, which is only valid when
+	// they point to values allocated by new with UseHeap and are not
+	// copied. MCU sketches usually never free memory; this is meant for
+	// running the output on a host. It requires C++.
+	GenerateDestructors bool
+	// LocalImports lists the import paths of the packages of the module
+	// preceding the transpiled file in the output. It is set by
+	// TranspilePackage.
+	LocalImports []string `json:"-"`
+	// ArduinoMode turns the main function into setup and loop: the body of
+	// its infinite for loop is run by loop and the statements before it by
+	// setup.
+	ArduinoMode bool
+	// Verbose makes Transpile log the declarations, statements and
+	// expressions it handles, with their position, to help diagnose
+	// failures.
+	Verbose bool
+	// DeadCodeElim leaves out the functions which cannot be reached from
+	// setup, loop or main.
+	DeadCodeElim bool
+	// MacroDefaults replaces the if statements a function starts with to
+	// give a parameter a default value when it is zero by a call to a
+	// NAME_DEFAULT_PARAM macro defined before the function and undefined
+	// after it.
+	MacroDefaults bool
+	// RecursionWarning adds a #warning to the output for each function
+	// which may call itself, as recursion easily overflows the stack of
+	// an MCU. If nil, it is enabled when a Target is set.
+	RecursionWarning *bool
+	// CppStandard is the standard the output must conform to: "c99",
+	// "c++03", "c++11", the default, "c++14" or "c++17". It selects the
+	// features used for the constructs which differ; with "c99", structs
+	// are typedefed, comments are block comments and bool comes from
+	// stdbool.h.
+	CppStandard string
+	// PreserveComments copies the comments of the Go source to the output,
+	// next to the declaration or statement they are associated with.
+	PreserveComments bool
+	// LineDirectives precedes the declarations and statements with #line
+	// directives giving their position in the Go source, which compiler
+	// errors then refer to. Compile relies on them.
+	LineDirectives bool
+	// IndentStyle is either "spaces", the default, or "tabs".
+	IndentStyle string
+	// IndentWidth is the number of spaces or tabs statements are indented
+	// by. Zero means 2 for spaces and 1 for tabs.
+	IndentWidth int
+	// Ext is the extension of the file written by TranspileFile when no
+	// output path is given. Empty means "cc".
+	Ext string
+	// CacheDir is the directory where TranspileFile records the hash of
+	// the files it transpiles, to skip them while they and the options
+	// are unchanged. Empty means ".mugo_cache". A relative path is
+	// relative to the directory of the input file.
+	CacheDir string
+	// NoCache makes TranspileFile always transpile.
+	NoCache bool
+	// SerialBaud is the baud rate Serial is initialized with at the start
+	// of setup when the sketch prints with fmt or log. Zero means 9600, a
+	// negative value disables the initialization.
+	SerialBaud int
+	// Target is the MCU family the output is compiled for, either "avr" or
+	// "esp32". It sets the default MaxRAM, the headers included and the
+	// type mappings specific to the target.
+	Target string
+	// IntWidth is the width in bits of int and uint in the output, either
+	// 16 or 32. Go's int is 64 bits wide on most hosts, while C++'s int is
+	// as narrow as 16 bits on some MCUs, so the width is made explicit with
+	// int16_t or int32_t. Zero means the width of Target, if known, and
+	// C++'s int otherwise.
+	IntWidth int
+	// MaxRAM is the RAM size in bytes the //mugo:arena annotation is
+	// checked against. Zero means the RAM size of Target, if known.
+	MaxRAM int
+	// Format pipes the output through clang-format, if it is installed.
+	Format bool
+	// Peephole removes the statements of the output which have no effect,
+	// such as assignments of a variable to itself.
+	Peephole bool
+	// StrictMode rejects expressions whose operand types are invalid in
+	// Go, as far as they can be inferred, instead of transpiling them.
+	StrictMode bool
+	// TypeCheck runs the Go type checker on the source, importing the
+	// packages of its module from source, and uses its results where the
+	// types of expressions cannot be inferred otherwise, such as the
+	// values returned by functions of other packages. TranspileFile does
+	// not use its cache then.
+	TypeCheck bool
+	// Dir is the directory of the source, which imports are resolved from
+	// with TypeCheck. Empty means the current directory.
+	Dir string `json:"-"`
+	// Compiler is the command Compile runs, with its leading arguments.
+	// Empty means "avr-gcc".
+	Compiler string
+	// MCU is the microcontroller Compile builds for, passed as -mmcu.
+	// Empty means "atmega328p".
+	MCU string
+	// CPUFrequency is the clock frequency in Hz Compile defines as F_CPU.
+	// Zero means 16 MHz.
+	CPUFrequency int
+}
+
+// output carries the state of a single transpilation along with the Writer
+// the generated code goes to.
+type output struct {
+	io.Writer
+	opts     *Options
+	target   *TargetConfig
+	features featureSet
+	fset     *token.FileSet
+	// content is the Go source being transpiled.
+	content []byte
+	// parseErrors holds the syntax errors of content, which is only
+	// transpiled despite them with Options.BatchErrors, and cited those
+	// reported for the bad nodes standing for the code they are in.
+	parseErrors scanner.ErrorList
+	cited       map[*scanner.Error]bool
+	// types maps package level type names to their declaration.
+	types map[string]*ast.TypeSpec
+	// methods maps type names to their methods.
+	methods map[string]map[string]*ast.FuncDecl
+	// funcs maps function names to their declaration.
+	funcs map[string]*ast.FuncDecl
+	// inits lists the init functions in declaration order.
+	inits []*ast.FuncDecl
+	// reachable holds the functions to emit with Options.DeadCodeElim, by
+	// C++ name.
+	reachable map[string]bool
+	// imports maps the names of imported packages to the header they
+	// stand for.
+	imports map[string]include
+	// includes lists the headers requested with //mugo:c_include.
+	includes []string
+	// headers records the headers of the imported packages included so
+	// far.
+	headers map[string]bool
+	// declared records the structs emitted so far, including forward
+	// declarations.
+	declared map[string]bool
+	// defined records the structs whose definition has been emitted.
+	defined map[string]bool
+	// symbols maps variable names to their C++ type, in the scopes
+	// enclosing the current statement.
+	symbols *symbolTable
+	// lengths maps the slices backed by an array, which can be appended
+	// to, to the variable holding their length. Slices are keyed by their
+	// object, as other variables of the same name may be declared in
+	// other scopes.
+	lengths map[*ast.Object]string
+	// bindings maps interface variables to the concrete type they hold.
+	bindings map[*ast.Object]binding
+	// unsupported records the unsupported constructs encountered.
+	unsupported *[]TranspileError
+	// results is the name of the struct holding the values returned by the
+	// current function, if it returns more than one.
+	results string
+	// returns lists the C++ types of the values returned by the current
+	// function.
+	returns []string
+	// tmps counts the temporary variables introduced so far.
+	tmps *int
+	// vtables records the method tables used with the "vtable" interface
+	// dispatch.
+	vtables *[]vtableUse
+	// comments holds the comments of the file, if they are to be
+	// preserved.
+	comments []*ast.CommentGroup
+	// emitPos is the end of the source emitted so far; comments before it
+	// have been emitted or dropped.
+	emitPos *token.Pos
+	// indentStr is the string statements are indented with.
+	indentStr string
+	// serialBaud, if positive, is the baud rate Serial must be initialized
+	// with in setup.
+	serialBaud int
+	// scope is the package scope, telling globals from local variables.
+	scope *ast.Scope
+	// before receives the functions generated for function literals, which
+	// are emitted before the declaration using them.
+	before *bytes.Buffer
+	// prelude receives the declarations of the temporaries the current
+	// statement needs, which are emitted before it.
+	prelude *bytes.Buffer
+	// lambdas counts the functions generated for function literals.
+	lambdas *int
+	// arenaSize is the size of the arena new allocates from, if any.
+	arenaSize int
+	// helpers records the helper functions the output uses.
+	helpers map[string]bool
+	// iota is the value of iota in the constant declaration being handled.
+	iota int
+	// verbose is set by Options.Verbose.
+	verbose bool
+	// intWidth is the width of int in bits, or zero to use C++'s int.
+	intWidth int
+	// info holds the results of Options.TypeCheck, if set.
+	info *types.Info
+	// pkg is the name of the package transpiled.
+	pkg string
+	// symbolMap maps the Go names of the functions emitted to their C++
+	// symbol, if Options.SymbolMap is set.
+	symbolMap map[string]Symbol
+}
+
+// to returns a copy of out which shares its state but writes to w.
+func (o *output) to(w io.Writer) *output {
+	c := *o
+	c.Writer = w
+	return &c
+}
+
+// probe returns a copy of out for finding out about the code, such as the
+// type of an expression, which does not record the unsupported constructs
+// it runs into: they are reported when the code is handled.
+func (o *output) probe() *output {
+	c := *o
+	c.unsupported = &[]TranspileError{}
+	return &c
+}
+
+// trace logs that n is being handled, if verbose.
+func (o *output) trace(n ast.Node) {
+	if o.verbose {
+		log.Printf("%v: handling %T", o.fset.Position(n.Pos()), n)
+	}
+}
+
+// tmp returns the name of a new temporary variable.
+func (o *output) tmp() string {
+	name := fmt.Sprintf("__mugo_r%d", *o.tmps)
+	*o.tmps++
+	return name
+}
+
+// symbolTable maps variable names to their C++ type in nested scopes, the
+// innermost last.
+type symbolTable struct {
+	scopes []map[string]string
+	// exits holds, for each scope, the functions undoing what is only
+	// valid in it.
+	exits [][]func()
+}
+
+// newSymbolTable returns a symbol table holding the package scope.
+func newSymbolTable() *symbolTable {
+	return &symbolTable{scopes: []map[string]string{{}}, exits: [][]func(){nil}}
+}
+
+// pushScope starts a scope nested in the current one.
+func (s *symbolTable) pushScope() {
+	s.scopes = append(s.scopes, map[string]string{})
+	s.exits = append(s.exits, nil)
+}
+
+// popScope ends the current scope, forgetting the variables declared in it
+// and calling the functions registered with atExit, the last first.
+func (s *symbolTable) popScope() {
+	exits := s.exits[len(s.exits)-1]
+	for i := len(exits) - 1; i >= 0; i-- {
+		exits[i]()
+	}
+	s.scopes = s.scopes[:len(s.scopes)-1]
+	s.exits = s.exits[:len(s.exits)-1]
+}
+
+// atExit registers f to be called when the current scope ends.
+func (s *symbolTable) atExit(f func()) {
+	s.exits[len(s.exits)-1] = append(s.exits[len(s.exits)-1], f)
+}
+
+// define records the type of a variable declared in the current scope,
+// which shadows those of the same name in the enclosing scopes.
+func (s *symbolTable) define(name, typ string) {
+	s.scopes[len(s.scopes)-1][name] = typ
+}
+
+// lookup returns the type of the variable name refers to in the current
+// scope, and whether there is one.
+func (s *symbolTable) lookup(name string) (string, bool) {
+	for i := len(s.scopes) - 1; i >= 0; i-- {
+		if typ, ok := s.scopes[i][name]; ok {
+			return typ, true
+		}
+	}
+	return "", false
+}
+
+// indentString returns the indentation selected by opts.
+func indentString(opts *Options) (string, error) {
+	width := opts.IndentWidth
+	if width < 0 {
+		return "", fmt.Errorf("invalid indent width %d", width)
+	}
+	switch opts.IndentStyle {
+	case "", "spaces":
+		if width == 0 {
+			width = 2
+		}
+		return strings.Repeat(" ", width), nil
+	case "tabs":
+		if width == 0 {
+			width = 1
+		}
+		return strings.Repeat("\t", width), nil
+	default:
+		return "", fmt.Errorf("unknown indent style %q", opts.IndentStyle)
+	}
+}
+
+// formatSourceContext returns the source lines around pos, contextLines
+// before and after it, with a ^ under the column of pos.
+func (o *output) formatSourceContext(pos token.Pos, contextLines int) string {
+	p := o.fset.Position(pos)
+	lines := strings.Split(string(o.content), "\n")
+	first, last := p.Line-contextLines, p.Line+contextLines
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	var b bytes.Buffer
+	for n := first; n <= last; n++ {
+		line := lines[n-1]
+		fmt.Fprintf(&b, "%4d | %s\n", n, line)
+		if n != p.Line {
+			continue
+		}
+		// Keep the tabs so that the marker lines up with the source.
+		marker := []byte{}
+		for i := 0; i < p.Column-1 && i < len(line); i++ {
+			if line[i] == '\t' {
+				marker = append(marker, '\t')
+			} else {
+				marker = append(marker, ' ')
+			}
+		}
+		fmt.Fprintf(&b, "     | %s^\n", marker)
+	}
+	return b.String()
+}
+
+// line returns the line of the source file n starts at.
+func (o *output) line(n ast.Node) int {
+	return o.fset.Position(n.Pos()).Line
+}
+
+// basicTypes maps Go predeclared types to their C++ equivalent.
+var basicTypes = map[string]string{
+	// any holds values of any type, which are passed by address.
+	"any":     "void*",
+	"bool":    "bool",
+	"byte":    "uint8_t",
+	"float32": "float",
+	"float64": "double",
+	"int":     "int",
+	"int8":    "int8_t",
+	"int16":   "int16_t",
+	"int32":   "int32_t",
+	"int64":   "int64_t",
+	"rune":    "int32_t",
+	"string":  "const char *",
+	"uint":    "unsigned int",
+	"uint8":   "uint8_t",
+	"uint16":  "uint16_t",
+	"uint32":  "uint32_t",
+	"uint64":  "uint64_t",
+}
+
+// basicType returns the C++ equivalent of the Go predeclared type name, with
+// int and uint as wide as selected by Options.IntWidth.
+func (o *output) basicType(name string) (string, bool) {
+	if o.intWidth != 0 {
+		switch name {
+		case "int":
+			return fmt.Sprintf("int%d_t", o.intWidth), true
+		case "uint":
+			return fmt.Sprintf("uint%d_t", o.intWidth), true
+		}
+	}
+	t, ok := basicTypes[name]
+	return t, ok
+}
+
 // Transpile reads Go source code from the given Reader and writes the
-// transpiled Arduino C++ code to the given Writer.
-func Transpile(out io.Writer, in io.Reader, debug io.Writer) error {
+// transpiled Arduino C++ code to the given Writer. A nil opts is the same as
+// an empty Options.
+func Transpile(out io.Writer, in io.Reader, opts *Options) error {
+	_, err := transpile(out, in, opts)
+	return err
+}
+
+// TranspileBytes is like Transpile but reads the Go source code from src.
+// It returns the parsed file, which is nil if src could not be parsed.
+func TranspileBytes(out io.Writer, src []byte, opts *Options) (*ast.File, error) {
+	return transpile(out, src, opts)
+}
+
+// TranspileFile transpiles the Go source file in and writes the result to
+// the file out. If out is empty, it is in with its extension replaced by
+// opts.Ext. The output file is only written if transpilation succeeds, and
+// not at all if in was already transpiled to it with the same options,
+// unless opts.NoCache or opts.TypeCheck is set.
+func TranspileFile(in, out string, opts *Options) error {
+	src, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		ext := ""
+		if opts != nil {
+			ext = opts.Ext
+		}
+		out = OutputPath(in, ext)
+	}
+	var cache, hash string
+	// With TypeCheck, the output also depends on the imported packages,
+	// which the hash does not cover.
+	if opts == nil || !opts.NoCache && !opts.TypeCheck {
+		cache = cachePath(in, opts)
+		if hash, err = cacheHash(src, opts); err != nil {
+			return err
+		}
+		if cached(cache, in, out, hash) {
+			return nil
+		}
+	}
+	if opts != nil && opts.TypeCheck && opts.Dir == "" {
+		o := *opts
+		o.Dir = filepath.Dir(in)
+		opts = &o
+	}
+	var buf bytes.Buffer
+	if _, err := transpile(&buf, src, opts); err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if cache == "" {
+		return nil
+	}
+	return updateCache(cache, in, out, hash)
+}
+
+// OutputPath returns path with its extension replaced by ext, or "cc" if
+// ext is empty.
+func OutputPath(path, ext string) string {
+	if ext == "" {
+		ext = "cc"
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + strings.TrimPrefix(ext, ".")
+}
+
+// readSource returns the content of src, which is a Reader or a byte slice.
+func readSource(src interface{}) ([]byte, error) {
+	switch s := src.(type) {
+	case []byte:
+		return s, nil
+	case io.Reader:
+		return io.ReadAll(s)
+	default:
+		return nil, fmt.Errorf("invalid source type %T", src)
+	}
+}
+
+// transpile parses src, which is a Reader or a byte slice, and transpiles it.
+func transpile(out io.Writer, src interface{}, opts *Options) (*ast.File, error) {
+	return transpileNamed(out, "sketch.go", src, opts)
+}
+
+// transpileNamed is like transpile, with src named name in the positions
+// of the output.
+func transpileNamed(out io.Writer, name string, src interface{}, opts *Options) (*ast.File, error) {
+	content, err := readSource(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source: %v", err)
+	}
+	dir := ""
+	if opts != nil {
+		dir = opts.Dir
+	}
+	files, err := transpileFiles(out, []source{{name, content, dir}}, opts)
+	if len(files) == 0 {
+		return nil, err
+	}
+	return files[0], err
+}
+
+// source is a Go file to transpile.
+type source struct {
+	name    string
+	content []byte
+	// dir is the directory of the package of the file, used to resolve
+	// its imports with Options.TypeCheck.
+	dir string
+}
+
+// transpileFiles parses srcs and transpiles them together, as the files of
+// a single package: the helpers, temporaries and headers they need are only
+// emitted once. It returns the parsed files, which are nil if srcs could
+// not be parsed.
+func transpileFiles(out io.Writer, srcs []source, opts *Options) ([]*ast.File, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Format {
+		unformatted := *opts
+		unformatted.Format = false
+		var buf bytes.Buffer
+		files, err := transpileFiles(&buf, srcs, &unformatted)
+		if err != nil {
+			buf.WriteTo(out)
+			return files, err
+		}
+		_, err = out.Write(formatCpp(buf.Bytes()))
+		return files, err
+	}
+	if opts.Peephole {
+		plain := *opts
+		plain.Peephole = false
+		var buf bytes.Buffer
+		files, err := transpileFiles(&buf, srcs, &plain)
+		if err != nil {
+			buf.WriteTo(out)
+			return files, err
+		}
+		lines := peepholeOptimize(strings.SplitAfter(buf.String(), "\n"))
+		_, err = io.WriteString(out, strings.Join(lines, ""))
+		return files, err
+	}
+	switch opts.InterfaceDispatch {
+	case "", "static", "vtable":
+	default:
+		return nil, fmt.Errorf("unknown interface dispatch %q", opts.InterfaceDispatch)
+	}
+	switch opts.ErrorType {
+	case "", "int", "const char*":
+	default:
+		return nil, fmt.Errorf("unknown error type %q", opts.ErrorType)
+	}
+	target := getTargetConfig(opts.Target)
+	if target == nil {
+		return nil, fmt.Errorf("unknown target %q", opts.Target)
+	}
+	intWidth := opts.IntWidth
+	if intWidth == 0 {
+		intWidth = target.IntWidth
+	}
+	if intWidth != 0 && intWidth != 16 && intWidth != 32 {
+		return nil, fmt.Errorf("unsupported int width %d, expected 16 or 32", intWidth)
+	}
+	features, ok := stdFeatures(opts.CppStandard)
+	if !ok {
+		return nil, fmt.Errorf("unknown standard %q", opts.CppStandard)
+	}
+	indent, err := indentString(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, "sketch.go", in, 0)
+	files := make([]*ast.File, len(srcs))
+	parseErrors := make([]scanner.ErrorList, len(srcs))
+	for i, src := range srcs {
+		f, err := parser.ParseFile(fset, src.name, src.content, parser.ParseComments)
+		errs, ok := err.(scanner.ErrorList)
+		if err != nil && (!opts.BatchErrors || !ok || f == nil) {
+			return nil, fmt.Errorf("failed to parse file: %v", err)
+		}
+		if opts.Debug != nil {
+			ast.Fprint(opts.Debug, fset, f, nil)
+		}
+		files[i], parseErrors[i] = f, errs
+	}
+	// The analyses of the whole package see the declarations of all its
+	// files.
+	pkg := &ast.File{Name: files[0].Name}
+	for _, f := range files {
+		pkg.Decls = append(pkg.Decls, f.Decls...)
+	}
+
+	o := &output{
+		Writer:      out,
+		opts:        opts,
+		target:      target,
+		features:    features,
+		fset:        fset,
+		cited:       map[*scanner.Error]bool{},
+		types:       map[string]*ast.TypeSpec{},
+		methods:     map[string]map[string]*ast.FuncDecl{},
+		funcs:       map[string]*ast.FuncDecl{},
+		declared:    map[string]bool{},
+		defined:     map[string]bool{},
+		imports:     map[string]include{},
+		headers:     map[string]bool{},
+		helpers:     map[string]bool{},
+		lambdas:     new(int),
+		symbols:     newSymbolTable(),
+		lengths:     map[*ast.Object]string{},
+		bindings:    map[*ast.Object]binding{},
+		unsupported: &[]TranspileError{},
+		tmps:        new(int),
+		vtables:     &[]vtableUse{},
+		indentStr:   indent,
+		verbose:     opts.Verbose,
+		intWidth:    intWidth,
+	}
+	for _, f := range files {
+		collectTypes(o, f)
+		collectImports(o, f)
+	}
+	for _, inc := range o.includes {
+		fmt.Fprintf(out, "#include %s\n", inc)
+	}
+	if opts.PreserveComments {
+		o.comments = files[0].Comments
+		o.emitPos = new(token.Pos)
+		flushComments(o, files[0].Package, "")
+		advance(o, files[0].Name.End())
+	}
+	for _, inc := range target.Includes {
+		fmt.Fprintf(out, "#include %s\n", inc)
+	}
+	if !features.boolType {
+		fmt.Fprintln(out, "#include <stdbool.h>")
+	}
+	for i, f := range files {
+		size, err := arenaSize(o, f)
+		if err != nil {
+			return files, err
+		}
+		if size > 0 && o.arenaSize > 0 {
+			return files, fmt.Errorf("duplicate //mugo:arena annotation in %s", srcs[i].name)
+		}
+		if size > 0 {
+			o.arenaSize = size
+		}
+	}
+	if opts.SerialBaud >= 0 && usesSerial(pkg) {
+		o.serialBaud = opts.SerialBaud
+		if o.serialBaud == 0 {
+			o.serialBaud = 9600
+		}
+	}
+	if opts.TypeCheck {
+		o.info = &types.Info{Types: map[ast.Expr]types.TypeAndValue{}}
+		var dirs []string
+		pkgs := map[string][]*ast.File{}
+		for i, f := range files {
+			if _, ok := pkgs[srcs[i].dir]; !ok {
+				dirs = append(dirs, srcs[i].dir)
+			}
+			pkgs[srcs[i].dir] = append(pkgs[srcs[i].dir], f)
+		}
+		for _, dir := range dirs {
+			if err := typeCheck(fset, pkgs[dir], dir, o.info); err != nil {
+				return files, fmt.Errorf("failed to type check: %v", err)
+			}
+		}
+	}
+	if opts.SymbolMap != nil {
+		o.symbolMap = map[string]Symbol{}
+	}
+	if opts.DeadCodeElim {
+		o.reachable = ReachableFunctions(pkg, []string{"setup", "loop", "main"})
+	}
+	if !o.vtable() {
+		if err := bindInterfaces(o, pkg); err != nil {
+			return files, fmt.Errorf("failed to resolve interface dispatch: %v", err)
+		}
+	}
+	if recursionWarning(opts) {
+		for _, name := range RecursiveFunctions(pkg) {
+			fmt.Fprintf(out, "#warning \"mugo: recursive function %s may cause stack overflow on small MCUs\"\n", name)
+		}
+	}
+	if opts.MaxCodeSize > 0 {
+		total := 0
+		for _, size := range EstimateCodeSize(pkg) {
+			total += size
+		}
+		if total > opts.MaxCodeSize {
+			fmt.Fprintf(out, "#warning \"mugo: estimated code size of %d bytes exceeds the limit of %d bytes\"\n", total, opts.MaxCodeSize)
+		}
+	}
+
+	// The declarations are written after the helpers they turn out to
+	// need.
+	var body bytes.Buffer
+	o.Writer = &body
+	for i, f := range files {
+		o.content = srcs[i].content
+		o.parseErrors = parseErrors[i]
+		o.scope = f.Scope
+		o.pkg = f.Name.Name
+		if opts.PreserveComments && i > 0 {
+			o.comments = f.Comments
+			flushComments(o, f.Package, "")
+			advance(o, f.Name.End())
+		}
+		if err = handleDecls(o, f); err != nil {
+			break
+		}
+		for _, e := range o.parseErrors {
+			// Syntax errors not standing out as bad nodes are reported
+			// as is.
+			if !o.cited[e] {
+				*o.unsupported = append(*o.unsupported, TranspileError{Pos: e.Pos, Msg: e.Error()})
+			}
+		}
+	}
+	if err == nil {
+		err = handlePackageEnd(o, files[len(files)-1].End())
+	}
+	if o.arenaSize > 0 {
+		writeArena(out, o)
+	}
+	writeHelpers(out, o)
+	body.WriteTo(out)
 	if err != nil {
-		return fmt.Errorf("failed to parse file: %v", err)
+		return files, err
+	}
+	if o.symbolMap != nil {
+		if err := writeSymbolMap(opts.SymbolMap, o); err != nil {
+			return files, fmt.Errorf("failed to write the symbol map: %v", err)
+		}
+	}
+	if len(*o.unsupported) > 0 && (opts.SkipUnsupported || opts.BatchErrors) {
+		return files, ErrorList(*o.unsupported)
+	}
+	return files, nil
+}
+
+// recursionWarning reports whether recursive functions are warned about
+// with opts.
+func recursionWarning(opts *Options) bool {
+	if opts.RecursionWarning != nil {
+		return *opts.RecursionWarning
+	}
+	return opts.Target != ""
+}
+
+// handleDecls emits the declarations of f.
+func handleDecls(out *output, f *ast.File) error {
+	for _, d := range f.Decls {
+		flushComments(out, d.Pos(), "")
+		recorded := len(*out.unsupported)
+		var before, buf bytes.Buffer
+		o := out.to(&buf)
+		o.before = &before
+		err := handleDecl(o, d)
+		advance(out, d.End())
+		if err != nil {
+			if skip(out, d, err, recorded) {
+				continue
+			}
+			// Point at the unsupported construct if that is what
+			// failed, at the declaration otherwise.
+			pos := d.Pos()
+			if len(*out.unsupported) > recorded {
+				pos = (*out.unsupported)[len(*out.unsupported)-1].Node.Pos()
+			}
+			return fmt.Errorf("error handling decl at line %d: %v\n%s", out.fset.Position(pos).Line, err, out.formatSourceContext(pos, 2))
+		}
+		if before.Len() > 0 || buf.Len() > 0 {
+			lineDirective(out, d.Pos())
+		}
+		before.WriteTo(out)
+		buf.WriteTo(out)
+	}
+	tf := out.fset.File(f.Pos())
+	flushComments(out, token.Pos(tf.Base()+tf.Size()), "")
+	return nil
+}
+
+// handlePackageEnd emits what follows the declarations of all the files of
+// the package, which end at end: the method tables they use and setup, if
+// it must be generated.
+func handlePackageEnd(out *output, end token.Pos) error {
+	if err := writeTables(out); err != nil {
+		return fmt.Errorf("error writing method tables: %v", err)
+	}
+	if _, ok := out.funcs["setup"]; !ok && (out.serialBaud > 0 || len(out.inits) > 0) {
+		fmt.Fprintln(out, "void setup() {")
+		if err := handleSetupPrologue(out, end); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "}")
 	}
+	return nil
+}
 
-	if debug != nil {
-		ast.Fprint(debug, fset, f, nil)
+// usesSerial reports whether f prints with the fmt or log packages, which
+// write to Serial on the MCU. Skipped declarations are not considered.
+func usesSerial(f *ast.File) bool {
+	found := false
+	for _, d := range f.Decls {
+		if hasAnnotation([]*ast.CommentGroup{declDoc(d)}, d.Pos(), "skip") {
+			continue
+		}
+		ast.Inspect(d, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || found {
+				return !found
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			pkg, ok := sel.X.(*ast.Ident)
+			if !ok || pkg.Obj != nil {
+				return true
+			}
+			switch {
+			case pkg.Name == "fmt" && strings.HasPrefix(sel.Sel.Name, "Print"),
+				pkg.Name == "log" && strings.HasPrefix(sel.Sel.Name, "Print"):
+				found = true
+			case pkg.Name == "fmt" && sel.Sel.Name == "Fprintf" && len(call.Args) > 0:
+				if w, ok := call.Args[0].(*ast.SelectorExpr); ok {
+					if x, ok := w.X.(*ast.Ident); ok && x.Name == "os" && w.Sel.Name == "Stdout" {
+						found = true
+					}
+				}
+			}
+			return true
+		})
 	}
+	return found
+}
 
+// collectTypes records the types declared in f and their methods, so they
+// can be referred to before their declaration.
+func collectTypes(out *output, f *ast.File) {
 	for _, d := range f.Decls {
-		if err := handleDecl(out, d); err != nil {
-			return fmt.Errorf("error handling decl %#v: %v", d, err)
+		switch decl := d.(type) {
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, s := range decl.Specs {
+				ts := s.(*ast.TypeSpec)
+				out.types[ts.Name.Name] = ts
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil && decl.Name.Name == "init" {
+				// There may be several init functions, which are
+				// not called by name.
+				if !hasAnnotation([]*ast.CommentGroup{decl.Doc}, decl.Pos(), "skip") {
+					out.inits = append(out.inits, decl)
+				}
+				continue
+			}
+			if isArduinoMain(out, decl) {
+				// main stands for setup and loop, unless they are
+				// declared too.
+				for _, name := range []string{"setup", "loop"} {
+					if out.funcs[name] == nil {
+						out.funcs[name] = decl
+					}
+				}
+			}
+			if decl.Recv == nil {
+				out.funcs[decl.Name.Name] = decl
+				continue
+			}
+			name, _, ok := receiverType(decl)
+			if !ok {
+				continue
+			}
+			if out.methods[name] == nil {
+				out.methods[name] = map[string]*ast.FuncDecl{}
+			}
+			out.methods[name][decl.Name.Name] = decl
+		}
+	}
+}
+
+// receiverType returns the name of the type a method is declared on and
+// whether its receiver is a pointer.
+func receiverType(fd *ast.FuncDecl) (string, bool, bool) {
+	if fd.Recv == nil || len(fd.Recv.List) != 1 {
+		return "", false, false
+	}
+	t := fd.Recv.List[0].Type
+	star, pointer := t.(*ast.StarExpr)
+	if pointer {
+		t = star.X
+	}
+	id, ok := t.(*ast.Ident)
+	if !ok {
+		return "", false, false
+	}
+	return id.Name, pointer, true
+}
+
+// isInterface reports whether e names an interface type declared in the
+// transpiled file.
+func isInterface(out *output, e ast.Expr) bool {
+	id, ok := resolveAlias(out, e).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	ts, ok := out.types[id.Name]
+	if !ok {
+		return false
+	}
+	_, ok = ts.Type.(*ast.InterfaceType)
+	return ok
+}
+
+// valueStruct returns the name of the struct type contained in a value of
+// type e, directly or as array elements, if any.
+func valueStruct(out *output, e ast.Expr) string {
+	switch t := resolveAlias(out, e).(type) {
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return ""
+		}
+		return valueStruct(out, t.Elt)
+	case *ast.Ident:
+		if isStruct(out, t) {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+// resolveAlias returns the type aliased by e if e names a type alias, or e
+// itself.
+func resolveAlias(out *output, e ast.Expr) ast.Expr {
+	// Go rejects alias cycles, which cannot be longer than the number of
+	// types.
+	for i := 0; i <= len(out.types); i++ {
+		id, ok := e.(*ast.Ident)
+		if !ok {
+			return e
+		}
+		ts, ok := out.types[id.Name]
+		if !ok || ts.Assign == token.NoPos {
+			return e
+		}
+		e = ts.Type
+	}
+	return e
+}
+
+func exprTypeToType(out *output, e ast.Expr) (string, error) {
+	switch t := resolveAlias(out, e).(type) {
+	case *ast.Ident:
+		if ct, ok := out.basicType(t.Name); ok {
+			return ct, nil
+		}
+		if isInterface(out, t) && out.vtable() {
+			return t.Name, nil
+		}
+		if isInterface(out, t) {
+			return "", fmt.Errorf("interface %s is only supported as the type of an initialized variable", t.Name)
+		}
+		if ct, ok := out.opts.TypeMap[t.Name]; ok {
+			return ct, nil
+		}
+		if ct, ok := out.target.TypeMap[t.Name]; ok {
+			return ct, nil
+		}
+		if isError(t) {
+			return errorType(out), nil
+		}
+		return t.Name, nil
+	case *ast.SelectorExpr:
+		qualified := types.ExprString(t)
+		if ct, ok := out.opts.TypeMap[qualified]; ok {
+			return ct, nil
+		}
+		if ct, ok := out.target.TypeMap[qualified]; ok {
+			return ct, nil
+		}
+		if name, ok := importedName(out, t); ok {
+			return name, nil
+		}
+		return "", unsupported(out, e, "unsupported type %s of an unknown package (line %d)", qualified, out.line(e))
+	case *ast.StarExpr:
+		ct, err := exprTypeToType(out, t.X)
+		if err != nil {
+			return "", err
+		}
+		return ct + "*", nil
+	case *ast.MapType:
+		return "", unsupported(out, e, "map type %s is unsupported (line %d); consider a struct with fixed-size arrays", types.ExprString(t), out.line(e))
+	case *ast.InterfaceType:
+		if len(t.Methods.List) > 0 {
+			return "", unsupported(out, e, "unsupported interface literal type (line %d)", out.line(e))
+		}
+		// interface{} is the same as any.
+		return basicTypes["any"], nil
+	case *ast.Ellipsis:
+		// Variadic arguments are passed using C varargs, regardless of
+		// their type.
+		return "...", nil
+	case *ast.ArrayType:
+		elem, err := exprTypeToType(out, t.Elt)
+		if err != nil {
+			return "", err
+		}
+		if t.Len == nil {
+			// Slices are pointers to their first element.
+			return elem + "*", nil
+		}
+		var buf bytes.Buffer
+		if err := handleExpr(out.to(&buf), t.Len); err != nil {
+			return "", fmt.Errorf("error handling array length: %v", err)
+		}
+		// The length of the outer array comes first, as in Go.
+		if i := strings.Index(elem, "["); i >= 0 {
+			return fmt.Sprintf("%s[%s]%s", elem[:i], buf.String(), elem[i:]), nil
+		}
+		return fmt.Sprintf("%s[%s]", elem, buf.String()), nil
+	default:
+		return "", unsupported(out, e, "unsupported type %T (line %d)", e, out.line(e))
+	}
+}
+
+func handleDecl(out *output, d ast.Decl) error {
+	if hasAnnotation([]*ast.CommentGroup{declDoc(d)}, d.Pos(), "skip") {
+		fmt.Fprintln(out, out.comment("// MUGO SKIP: "+strings.Join(declNames(d), ", ")))
+		return nil
+	}
+	switch decl := d.(type) {
+	case *ast.GenDecl:
+		return handleGenDecl(out, decl)
+	case *ast.FuncDecl:
+		if name, err := funcName(decl); err == nil && out.reachable != nil && !out.reachable[name] {
+			fmt.Fprintln(out, out.comment("// mugo: removed unreachable function "+name))
+			return nil
+		}
+		return handleFuncDecl(out, decl)
+	case *ast.BadDecl:
+		return syntaxError(out, decl, decl.From, decl.To)
+	default:
+		return unsupported(out, d, "unsupported decl %T (line %d)", d, out.line(d))
+	}
+}
+
+func handleGenDecl(out *output, gd *ast.GenDecl) error {
+	if _, ok := annotation(gd.Doc, "c_code"); ok {
+		return handleCCode(out, gd)
+	}
+	// iota restarts at zero in each const declaration, and constants
+	// without a value repeat the expression of the previous one.
+	iota := 0
+	var prev *ast.ValueSpec
+	for _, s := range gd.Specs {
+		switch spec := s.(type) {
+		case *ast.ValueSpec:
+			o := out
+			if gd.Tok == token.CONST {
+				if len(spec.Values) == 0 && prev != nil {
+					spec = &ast.ValueSpec{Names: spec.Names, Type: prev.Type, Values: prev.Values}
+				}
+				prev = spec
+				o = out.to(out.Writer)
+				o.iota = iota
+				iota++
+			}
+			if err := handleValueSpec(o, spec); err != nil {
+				return err
+			}
+		case *ast.TypeSpec:
+			if err := handleTypeSpec(out, spec); err != nil {
+				return fmt.Errorf("error handling type %s: %v", spec.Name, err)
+			}
+		case *ast.ImportSpec:
+			if err := handleImportSpec(out, spec); err != nil {
+				return err
+			}
+		default:
+			return unsupported(out, s, "unsupported spec %T (line %d)", s, out.line(s))
+		}
+	}
+	return nil
+}
+
+func handleValueSpec(out *output, vs *ast.ValueSpec) error {
+	if len(vs.Names) > 1 {
+		return fmt.Errorf("unsupported # of value names: %v", vs.Names)
+	}
+	name := vs.Names[0]
+	decl := []string{}
+	if len(vs.Values) > 1 || len(vs.Values) == 0 && vs.Type == nil {
+		return fmt.Errorf("unsupported # of values: %v", vs.Names)
+	}
+	var value ast.Expr
+	if len(vs.Values) == 1 {
+		value = vs.Values[0]
+	}
+	var typ string
+	if b, ok := out.bindings[name.Obj]; ok {
+		typ = b.typeName
+		if b.instance {
+			// The variable holds the only reference to the literal, so
+			// it can store the value itself.
+			value = value.(*ast.UnaryExpr).X
+		} else if b.pointer {
+			typ += "*"
+		}
+	} else if vs.Type != nil {
+		t, err := exprTypeToType(out, vs.Type)
+		if err != nil {
+			return fmt.Errorf("error handling type of %s: %v", name, err)
+		}
+		typ = t
+	} else {
+		typ = typeFromExpr(out, value)
+		if typ == "" {
+			if err := valueError(out, value); err != nil {
+				return err
+			}
+		}
+		if _, ok := value.(*ast.CallExpr); ok && typ == "" {
+			// Only the result type of functions declared in the file
+			// is known.
+			return fmt.Errorf("cannot determine the type of %s from the result of %s; declare it explicitly (line %d)", name, types.ExprString(value.(*ast.CallExpr).Fun), out.line(vs))
+		}
+		if typ == "" {
+			return fmt.Errorf("cannot determine the type of %s", name)
+		}
+	}
+	if se, ok := arraySlice(out, value); ok {
+		return handleArraySlice(out, name, se)
+	}
+	if _, ok := sliceLit(out, value); ok {
+		if err := handleSliceLitDecl(out, name, value.(*ast.CompositeLit)); err != nil {
+			return err
+		}
+		fmt.Fprint(out, ";\n")
+		return nil
+	}
+	init := zeroValue(out, typ)
+	if value != nil && out.vtable() && isInterface(out, vs.Type) {
+		var decls bytes.Buffer
+		v, err := interfaceValue(out, typ, value, &decls)
+		if err != nil {
+			return fmt.Errorf("error handling value of %s: %v", name, err)
+		}
+		// Temporaries precede the declaration, indented alike.
+		indent := out.indentStr
+		if out.scope.Lookup(name.Name) == name.Obj {
+			indent = ""
+		}
+		for _, d := range strings.SplitAfter(decls.String(), "\n") {
+			if d != "" {
+				fmt.Fprint(out, d, indent)
+			}
+		}
+		init, value = v, nil
+	}
+	if value != nil {
+		value = errorValue(out, typ, value)
+		var buf bytes.Buffer
+		if err := handleExpr(out.to(&buf), value); err != nil {
+			return fmt.Errorf("error handling value of %s: %v", name, err)
+		}
+		init = buf.String()
+	}
+	out.symbols.define(name.Name, typ)
+	declType := typ
+	if name.Obj.Kind == ast.Con {
+		if strings.HasSuffix(typ, "*") {
+			// The pointer is constant, as in const char * const, which
+			// also works for types already pointing to constants.
+			declType += " const"
+		} else {
+			decl = append(decl, "const")
+		}
+	}
+	decl = append(decl, declaration(declType, name.Name), "=", init)
+	fmt.Fprintf(out, "%s;\n", strings.Join(decl, " "))
+	return nil
+}
+
+// arraySlice returns e if it slices the start of an array, as in buf[:n],
+// giving a slice which can be appended to up to the length of the array.
+func arraySlice(out *output, e ast.Expr) (*ast.SliceExpr, bool) {
+	se, ok := e.(*ast.SliceExpr)
+	if !ok || se.Low != nil || se.High == nil || se.Max != nil {
+		return nil, false
+	}
+	return se, strings.HasSuffix(typeFromExpr(out, se.X), "]")
+}
+
+// handleArraySlice declares the slice name pointing to the array sliced by
+// se, along with the variable holding its length.
+func handleArraySlice(out *output, name *ast.Ident, se *ast.SliceExpr) error {
+	t := typeFromExpr(out, se.X)
+	typ := t[:strings.Index(t, "[")] + "*"
+	var array, length bytes.Buffer
+	if err := handleExpr(out.to(&array), se.X); err != nil {
+		return fmt.Errorf("error handling the array sliced by %s: %v", name, err)
+	}
+	if err := handleExpr(out.to(&length), se.High); err != nil {
+		return fmt.Errorf("error handling the length of %s: %v", name, err)
+	}
+	out.symbols.define(name.Name, typ)
+	if name.Obj != nil {
+		out.lengths[name.Obj] = name.Name + "_len"
+	}
+	fmt.Fprintf(out, "%s = %s; %s %s_len = %s;\n", declaration(typ, name.Name), array.String(), tokenStr(out, token.INT), name.Name, length.String())
+	return nil
+}
+
+// declaration returns the C++ declaration of name with type typ, in which
+// array dimensions follow the name.
+func declaration(typ, name string) string {
+	if i := strings.Index(typ, "["); i >= 0 {
+		return typ[:i] + " " + name + typ[i:]
+	}
+	return typ + " " + name
+}
+
+// zeroValue returns the initializer of a variable of type typ declared
+// without a value, which Go sets to the zero value.
+func zeroValue(out *output, typ string) string {
+	switch {
+	case typ == "bool":
+		return "false"
+	case typ == basicTypes["string"]:
+		return `""`
+	case strings.HasSuffix(typ, "*"):
+		return "0"
+	case strings.HasSuffix(typ, "]"):
+		return emptyInit(out)
+	}
+	for _, t := range basicTypes {
+		if t == typ {
+			return "0"
+		}
+	}
+	return emptyInit(out)
+}
+
+// emptyInit returns the initializer list setting all the elements or fields
+// to zero. C requires at least one initializer.
+func emptyInit(out *output) string {
+	if out.features.cpp {
+		return "{}"
+	}
+	return "{0}"
+}
+
+func handleTypeSpec(out *output, ts *ast.TypeSpec) error {
+	out.trace(ts)
+	if _, ok := out.opts.TypeMap[ts.Name.Name]; ok {
+		// The type is provided by the C++ side.
+		return nil
+	}
+	if ts.Assign != token.NoPos {
+		// Aliases are resolved when used; the typedef only makes the
+		// name available to hand written C++.
+		if isInterface(out, ts.Type) {
+			return nil
+		}
+		typ, err := exprTypeToType(out, ts.Type)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "typedef %s %s;\n", typ, ts.Name)
+		return nil
+	}
+	switch t := ts.Type.(type) {
+	case *ast.StructType:
+		if out.defined[ts.Name.Name] {
+			// Already emitted as a dependency of another struct.
+			return nil
+		}
+		out.defined[ts.Name.Name] = true
+		// Structs contained by value must be defined first.
+		for _, f := range t.Fields.List {
+			if dep := valueStruct(out, f.Type); dep != "" && !out.defined[dep] {
+				if err := handleTypeSpec(out, out.types[dep]); err != nil {
+					return fmt.Errorf("error handling type %s: %v", dep, err)
+				}
+			}
+		}
+		// Structs pointed to before their definition, including this one,
+		// must be declared first.
+		for _, f := range t.Fields.List {
+			star, ok := f.Type.(*ast.StarExpr)
+			if !ok || !isStruct(out, star.X) {
+				continue
+			}
+			if name := resolveAlias(out, star.X).(*ast.Ident).Name; !out.declared[name] {
+				declareStruct(out, name)
+			}
+		}
+		if !out.declared[ts.Name.Name] && !out.features.cpp {
+			// C refers to structs by their tag otherwise.
+			declareStruct(out, ts.Name.Name)
+		}
+		out.declared[ts.Name.Name] = true
+		fmt.Fprintf(out, "struct %s {\n", ts.Name)
+		for _, f := range t.Fields.List {
+			if isInterface(out, f.Type) && out.vtable() && len(f.Names) == 0 {
+				// An embedded interface is a field named after it.
+				fmt.Fprintf(out, "%s%s %[2]s;\n", out.indentStr, f.Type)
+				continue
+			}
+			if isInterface(out, f.Type) && !out.vtable() {
+				// Interface values are held as untyped pointers,
+				// which are null in the zero value. They get no
+				// default member initializer, which would keep the
+				// struct from being an aggregate in C++11.
+				names := f.Names
+				if len(names) == 0 {
+					names = []*ast.Ident{f.Type.(*ast.Ident)}
+				}
+				for _, n := range names {
+					fmt.Fprintf(out, "%svoid* %s;\n", out.indentStr, n)
+				}
+				continue
+			}
+			if len(f.Names) == 0 {
+				return fmt.Errorf("unsupported embedded field: %#v", f.Type)
+			}
+			typ, err := exprTypeToType(out, f.Type)
+			if err != nil {
+				return fmt.Errorf("error handling field type: %v", err)
+			}
+			for _, n := range f.Names {
+				fmt.Fprintf(out, "%s%s;\n", out.indentStr, declaration(typ, n.Name))
+			}
+		}
+		if out.opts.GenerateDestructors {
+			if err := writeDestructor(out, ts.Name.Name, t); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(out, "};")
+		return nil
+	case *ast.InterfaceType:
+		if out.vtable() {
+			mt, err := newMethodTable(out, ts.Name.Name)
+			if err != nil {
+				return err
+			}
+			mt.writeTypes(out)
+			return nil
+		}
+		// Interfaces are dispatched statically and need no declaration.
+		return nil
+	default:
+		typ, err := exprTypeToType(out, ts.Type)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "typedef %s %s;\n", typ, ts.Name)
+		return nil
+	}
+}
+
+// declareStruct emits the forward declaration of the struct name, which C
+// refers to through a typedef.
+func declareStruct(out *output, name string) {
+	if out.features.cpp {
+		fmt.Fprintf(out, "struct %s;\n", name)
+	} else {
+		fmt.Fprintf(out, "typedef struct %[1]s %[1]s;\n", name)
+	}
+	out.declared[name] = true
+}
+
+// extractArgumentsType returns the C++ parameters of fd, starting with the
+// receiver for methods, and records them in the symbol table.
+func extractArgumentsType(out *output, fd *ast.FuncDecl) ([]string, error) {
+	var recv []*ast.Field
+	if fd.Recv != nil {
+		recv = fd.Recv.List
+	}
+	args := []string{}
+	for i, f := range append(recv[:len(recv):len(recv)], fd.Type.Params.List...) {
+		if mt, ok := f.Type.(*ast.MapType); ok {
+			return nil, unsupported(out, mt, "%s parameter is unsupported (line %d); consider passing a struct with fixed-size arrays or a pointer to a C-style associative structure", types.ExprString(mt), out.line(mt))
+		}
+		typ, err := exprTypeToType(out, f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported param type: %v", err)
+		}
+		if len(f.Names) == 0 || typ == "..." {
+			args = append(args, typ)
+			continue
+		}
+		for _, n := range f.Names {
+			out.symbols.define(n.Name, typ)
+			if i >= len(recv) && passByReference(out, fd, f.Type, n) {
+				args = append(args, "const "+typ+"& "+n.Name)
+				continue
+			}
+			args = append(args, typ+" "+n.Name)
+		}
+	}
+	return args, nil
+}
+
+// passByReference reports whether the parameter name of type t is a struct
+// large enough to be passed by const reference rather than copied on the
+// stack. Parameters modified by fd are always passed by value, as are those
+// of external functions, whose signature is not ours to change.
+func passByReference(out *output, fd *ast.FuncDecl, t ast.Expr, name *ast.Ident) bool {
+	if !out.features.cpp {
+		// C has no references.
+		return false
+	}
+	if fd.Body == nil {
+		return false
+	}
+	threshold := out.opts.LargeStructThreshold
+	if threshold == 0 {
+		threshold = 4
+	}
+	id, ok := t.(*ast.Ident)
+	if threshold < 0 || !ok || !isStruct(out, id) || typeWidth(out, id) <= threshold {
+		return false
+	}
+	modified := false
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range node.Lhs {
+				modified = modified || rootIdent(lhs) == name.Obj
+			}
+		case *ast.IncDecStmt:
+			modified = modified || rootIdent(node.X) == name.Obj
+		case *ast.UnaryExpr:
+			modified = modified || node.Op == token.AND && rootIdent(node.X) == name.Obj
+		case *ast.CallExpr:
+			// Methods with a pointer receiver may modify their receiver.
+			if sel, ok := node.Fun.(*ast.SelectorExpr); ok && rootIdent(sel.X) == name.Obj {
+				if m, ok := out.methods[id.Name][sel.Sel.Name]; ok {
+					_, pointer, _ := receiverType(m)
+					modified = modified || pointer
+				}
+			}
+		}
+		return !modified
+	})
+	return !modified
+}
+
+// rootIdent returns the object of the variable an expression like a.b[i].c
+// refers to, or nil if there is none.
+func rootIdent(e ast.Expr) *ast.Object {
+	switch expr := e.(type) {
+	case *ast.Ident:
+		return expr.Obj
+	case *ast.SelectorExpr:
+		return rootIdent(expr.X)
+	case *ast.IndexExpr:
+		return rootIdent(expr.X)
+	case *ast.ParenExpr:
+		return rootIdent(expr.X)
+	default:
+		return nil
+	}
+}
+
+// isStruct reports whether e names a struct type declared in the
+// transpiled file.
+func isStruct(out *output, e ast.Expr) bool {
+	id, ok := resolveAlias(out, e).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	ts, ok := out.types[id.Name]
+	if !ok {
+		return false
+	}
+	_, ok = ts.Type.(*ast.StructType)
+	return ok
+}
+
+func handleFuncDecl(out *output, fd *ast.FuncDecl) error {
+	out.trace(fd)
+	// The parameters are local to the function.
+	out.symbols.pushScope()
+	defer out.symbols.popScope()
+	if vector, ok := annotation(fd.Doc, "isr"); ok {
+		recordSymbol(out, fd, vector)
+		return handleISR(out, fd, vector)
+	}
+	if isArduinoMain(out, fd) {
+		recordSymbol(out, fd, "setup")
+		return handleArduinoMain(out, fd)
+	}
+	name, err := funcName(fd)
+	if err != nil {
+		return err
+	}
+	if fd.Recv == nil && name == "init" {
+		if out.opts.InlineInit {
+			// The body is emitted in setup.
+			return nil
+		}
+		name = initName(out, fd)
+	}
+	recordSymbol(out, fd, name)
+	results, err := resultTypes(out, fd.Type)
+	if err != nil {
+		return fmt.Errorf("unsupported return type: %v", err)
+	}
+	ret := "void"
+	out.results = ""
+	out.returns = results
+	switch len(results) {
+	case 0:
+	case 1:
+		ret = results[0]
+	default:
+		// Multiple values are returned in a struct.
+		ret = name + "_result"
+		fmt.Fprintf(out, "struct %s {\n", ret)
+		for i, t := range results {
+			fmt.Fprintf(out, "%s%s r%d;\n", out.indentStr, t, i)
+		}
+		fmt.Fprintln(out, "};")
+		out.results = ret
+	}
+	args, err := extractArgumentsType(out, fd)
+	if err != nil {
+		return err
+	}
+	if fd.Body == nil {
+		// Functions without a body are implemented in C or assembly.
+		fmt.Fprintf(out, "extern %s %s(%s);\n", ret, name, strings.Join(args, ", "))
+		return nil
+	}
+	body := fd.Body
+	var macros []string
+	if out.opts.MacroDefaults {
+		if body, macros, err = handleMacroDefaults(out, name, fd); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "%s %s(%s) {\n", ret, name, strings.Join(args, ", "))
+	if name == "setup" && fd.Recv == nil {
+		if err := handleSetupPrologue(out, fd.Pos()); err != nil {
+			return err
+		}
+	}
+	if err := handleBlockStmt(out, body); err != nil {
+		return fmt.Errorf("error handling block statement for %q: %v", fd.Name, err)
+	}
+	fmt.Fprintln(out, "}")
+	for _, m := range macros {
+		fmt.Fprintf(out, "#undef %s\n", m)
+	}
+	return nil
+}
+
+// writeDestructor emits the destructor of the struct name of type st, which
+// deletes its pointer fields, if it has any.
+func writeDestructor(out *output, name string, st *ast.StructType) error {
+	var fields []string
+	for _, f := range st.Fields.List {
+		if _, ok := f.Type.(*ast.StarExpr); !ok {
+			continue
+		}
+		for _, n := range f.Names {
+			fields = append(fields, n.Name)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	if !out.features.cpp {
+		return fmt.Errorf("destructors of %s require C++", name)
+	}
+	fmt.Fprintf(out, "%s~%s() {\n", out.indentStr, name)
+	for _, f := range fields {
+		fmt.Fprintf(out, "%s%sdelete %s;\n", out.indentStr, out.indentStr, f)
+	}
+	fmt.Fprintf(out, "%s}\n", out.indentStr)
+	return nil
+}
+
+// handleSetupPrologue emits what setup must do before its own statements:
+// open the serial port if needed, then run the init functions. pos is where
+// setup is defined; init functions defined after it are declared first.
+func handleSetupPrologue(out *output, pos token.Pos) error {
+	if out.serialBaud > 0 {
+		fmt.Fprintf(out, "%sSerial.begin(%d);\n", out.indentStr, out.serialBaud)
+	}
+	for _, fd := range out.inits {
+		if !out.opts.InlineInit {
+			if fd.Pos() > pos && out.before != nil {
+				fmt.Fprintf(out.before, "void %s();\n", initName(out, fd))
+			}
+			fmt.Fprintf(out, "%s%s();\n", out.indentStr, initName(out, fd))
+			continue
+		}
+		returns := false
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			switch n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ReturnStmt:
+				returns = true
+			}
+			return !returns
+		})
+		if returns {
+			return fmt.Errorf("init at line %d returns early and cannot be inlined in setup", out.line(fd))
+		}
+		// Each body gets a block of its own to keep its variables apart.
+		// Its comments have been dropped along with the declaration.
+		o := out.to(out.Writer)
+		o.emitPos = nil
+		fmt.Fprintf(out, "%s{\n", out.indentStr)
+		if err := handleBlockStmt(o, fd.Body); err != nil {
+			return fmt.Errorf("error handling block statement for init at line %d: %v", out.line(fd), err)
+		}
+		fmt.Fprintln(out, "}")
+	}
+	return nil
+}
+
+// initName returns the name of the C++ function an init function is emitted
+// as. Go allows several of them.
+func initName(out *output, fd *ast.FuncDecl) string {
+	if len(out.inits) == 1 {
+		return "__mugo_init"
+	}
+	for i, init := range out.inits {
+		if init == fd {
+			return fmt.Sprintf("__mugo_init%d", i)
+		}
+	}
+	return "__mugo_init"
+}
+
+// handleFuncLit emits a function literal as a function of its own, defined
+// before the current declaration, and refers to it by name. Only literals
+// which capture no local variable can be turned into C++ functions.
+func handleFuncLit(out *output, fl *ast.FuncLit) error {
+	var captured *ast.Ident
+	ast.Inspect(fl.Body, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || id.Obj == nil || id.Obj.Kind != ast.Var || captured != nil {
+			return captured == nil
+		}
+		decl, ok := id.Obj.Decl.(ast.Node)
+		if !ok || decl.Pos() >= fl.Pos() && decl.End() <= fl.End() || out.scope.Lookup(id.Name) == id.Obj {
+			return true
+		}
+		captured = id
+		return false
+	})
+	if captured != nil {
+		return unsupported(out, fl, "function literal captures the local variable %s (line %d); closures are not supported, use a package level variable instead", captured.Name, out.line(captured))
+	}
+	name := fmt.Sprintf("__mugo_lambda_%d", *out.lambdas)
+	*out.lambdas++
+	var buf bytes.Buffer
+	fd := &ast.FuncDecl{Name: ast.NewIdent(name), Type: fl.Type, Body: fl.Body}
+	if err := handleFuncDecl(out.to(&buf), fd); err != nil {
+		return fmt.Errorf("error handling function literal: %v", err)
+	}
+	buf.WriteTo(out.before)
+	fmt.Fprint(out, name)
+	return nil
+}
+
+// funcName returns the name of the C++ function implementing fd.
+func funcName(fd *ast.FuncDecl) (string, error) {
+	if fd.Recv == nil {
+		return fd.Name.Name, nil
+	}
+	t, _, ok := receiverType(fd)
+	if !ok {
+		return "", fmt.Errorf("unsupported receiver: %#v", fd.Recv)
+	}
+	return methodName(t, fd.Name.Name), nil
+}
+
+// resultTypes returns the C++ types of the values returned by a function.
+func resultTypes(out *output, ft *ast.FuncType) ([]string, error) {
+	types := []string{}
+	if ft.Results == nil {
+		return types, nil
+	}
+	for _, f := range ft.Results.List {
+		t, err := exprTypeToType(out, f.Type)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(f.Names) || i == 0; i++ {
+			types = append(types, t)
+		}
+	}
+	return types, nil
+}
+
+// handleISR emits fd as the interrupt service routine for the given vector.
+func handleISR(out *output, fd *ast.FuncDecl, vector string) error {
+	if vector == "" {
+		return fmt.Errorf("missing interrupt vector for ISR %s", fd.Name)
+	}
+	if fd.Recv != nil || len(fd.Type.Params.List) > 0 || fd.Type.Results != nil {
+		return fmt.Errorf("ISR %s must not have a receiver, parameters or return values", fd.Name)
+	}
+	if fd.Body == nil {
+		return fmt.Errorf("ISR %s must have a body", fd.Name)
+	}
+	fmt.Fprintf(out, "ISR(%s) {\n", vector)
+	if err := handleBlockStmt(out, fd.Body); err != nil {
+		return fmt.Errorf("error handling block statement for %q: %v", fd.Name, err)
+	}
+	fmt.Fprintln(out, "}")
+	return nil
+}
+
+// methodName returns the name of the C++ function implementing the method
+// name of the given type.
+func methodName(typeName, name string) string {
+	return typeName + "_" + name
+}
+
+func handleBlockStmt(out *output, bs *ast.BlockStmt) error {
+	out.symbols.pushScope()
+	defer out.symbols.popScope()
+	for _, s := range bs.List {
+		flushComments(out, s.Pos(), out.indentStr)
+		lineDirective(out, s.Pos())
+		fmt.Fprint(out, out.indentStr)
+		recorded := len(*out.unsupported)
+		var prelude, buf bytes.Buffer
+		o := out.to(&buf)
+		o.prelude = &prelude
+		err := handleStmt(o, s)
+		advance(out, s.End())
+		if err != nil {
+			if skip(out, s, err, recorded) {
+				continue
+			}
+			return err
+		}
+		for _, d := range strings.SplitAfter(prelude.String(), "\n") {
+			if d != "" {
+				fmt.Fprint(out, d, out.indentStr)
+			}
+		}
+		buf.WriteTo(out)
+	}
+	flushComments(out, bs.Rbrace, out.indentStr)
+	return nil
+}
+
+// lineDirective emits the #line directive of pos with
+// Options.LineDirectives.
+func lineDirective(out *output, pos token.Pos) {
+	if !out.opts.LineDirectives {
+		return
+	}
+	p := out.fset.Position(pos)
+	fmt.Fprintf(out, "#line %d %s\n", p.Line, strconv.Quote(p.Filename))
+}
+
+func handleStmt(out *output, s ast.Stmt) error {
+	out.trace(s)
+	if as, ok := s.(*ast.AssignStmt); ok && isAppend(as) {
+		return handleAppend(out, as)
+	}
+	switch st := s.(type) {
+	case *ast.ExprStmt, *ast.AssignStmt, *ast.IncDecStmt:
+		if err := handleSimpleStmt(out, st); err != nil {
+			return err
+		}
+		fmt.Fprint(out, ";\n")
+	case *ast.EmptyStmt:
+		// The block has already been indented for the statement, and an
+		// empty statement is valid C++ too.
+		fmt.Fprint(out, ";\n")
+	case *ast.DeclStmt:
+		gd, ok := st.Decl.(*ast.GenDecl)
+		if !ok {
+			return fmt.Errorf("unsupported declaration: %v", st.Decl)
+		}
+		if _, ok := annotation(gd.Doc, "c_code"); ok {
+			return handleCCode(out, gd)
+		}
+		if gd.Tok != token.VAR && gd.Tok != token.TYPE {
+			return fmt.Errorf("unsupported declaration: %v", st.Decl)
+		}
+		if gd.Tok == token.TYPE {
+			// Local types are defined in the function body, which C++
+			// allows too. They shadow the types of the same name until
+			// the end of the block.
+			for _, spec := range gd.Specs {
+				declareLocalType(out, spec.(*ast.TypeSpec))
+			}
+		}
+		if err := handleGenDecl(out, gd); err != nil {
+			return fmt.Errorf("error handling declaration: %v", err)
+		}
+	case *ast.ReturnStmt:
+		if len(st.Results) > 1 {
+			return handleMultiReturn(out, st)
+		}
+		fmt.Fprint(out, "return")
+		if len(st.Results) == 1 {
+			fmt.Fprint(out, " ")
+			if err := handleExpr(out, returnValue(out, 0, st.Results[0])); err != nil {
+				return fmt.Errorf("error handling return value %v: %v", st.Results[0], err)
+			}
+		}
+		fmt.Fprint(out, ";\n")
+	case *ast.IfStmt:
+		if err := handleIfStmt(out, st); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	case *ast.ForStmt:
+		return handleForStmt(out, st)
+	case *ast.RangeStmt:
+		return handleRangeStmt(out, st)
+	case *ast.SendStmt:
+		return unsupported(out, st, "channel send is not supported on MCU targets (line %d); replace with a direct function call or a shared variable with volatile qualifier", out.line(st))
+	case *ast.GoStmt:
+		return unsupported(out, st, "goroutine is not supported on MCU targets (line %d); consider using a cooperative scheduler library (e.g., Protothreads) or restructuring as a state machine", out.line(st))
+	default:
+		return unsupported(out, s, "unsupported statement %T (line %d)", s, out.line(s))
+	}
+	return nil
+}
+
+// declareLocalType records the type ts declared in the current block, and
+// restores the type of the same name it shadows at the end of the block.
+func declareLocalType(out *output, ts *ast.TypeSpec) {
+	name := ts.Name.Name
+	prev, ok := out.types[name]
+	defined, declared := out.defined[name], out.declared[name]
+	out.symbols.atExit(func() {
+		if ok {
+			out.types[name] = prev
+		} else {
+			delete(out.types, name)
+		}
+		out.defined[name], out.declared[name] = defined, declared
+	})
+	out.types[name] = ts
+	delete(out.defined, name)
+	delete(out.declared, name)
+}
+
+// handleIfStmt emits an if statement and its else branches, without the
+// final newline.
+func handleIfStmt(out *output, st *ast.IfStmt) error {
+	fmt.Fprintf(out, "if (")
+	if err := handleExpr(out, st.Cond); err != nil {
+		return fmt.Errorf("error handling if block conditionx: %v", err)
+	}
+	fmt.Fprint(out, ") {\n")
+	if err := handleBlockStmt(out, st.Body); err != nil {
+		return fmt.Errorf("error handling if block statements: %v", err)
+	}
+	fmt.Fprintf(out, "}")
+	switch e := st.Else.(type) {
+	case nil:
+	case *ast.IfStmt:
+		fmt.Fprint(out, " else ")
+		return handleIfStmt(out, e)
+	case *ast.BlockStmt:
+		fmt.Fprintf(out, " else {\n")
+		if err := handleBlockStmt(out, e); err != nil {
+			return fmt.Errorf("error handling else block statements: %v", err)
+		}
+		fmt.Fprintf(out, "}")
+	default:
+		return unsupported(out, st.Else, "unsupported else statement %T (line %d)", st.Else, out.line(st.Else))
+	}
+	return nil
+}
+
+// handleSimpleStmt handles the statements allowed in the header of a for
+// loop, without the terminating semicolon.
+func handleSimpleStmt(out *output, s ast.Stmt) error {
+	switch st := s.(type) {
+	case *ast.ExprStmt:
+		if err := handleExpr(out, st.X); err != nil {
+			return fmt.Errorf("error handling expr stmt %v: %v", st.X, err)
+		}
+	case *ast.AssignStmt:
+		return handleAssignStmt(out, st)
+	case *ast.IncDecStmt:
+		if err := handleExpr(out, st.X); err != nil {
+			return fmt.Errorf("error handling %v operand %v: %v", st.Tok, st.X, err)
+		}
+		fmt.Fprint(out, st.Tok)
+	default:
+		return unsupported(out, s, "unsupported statement %T (line %d)", s, out.line(s))
+	}
+	return nil
+}
+
+func handleAssignStmt(out *output, st *ast.AssignStmt) error {
+	if c, ok := st.Rhs[0].(*ast.CallExpr); ok && len(st.Lhs) > 1 && len(st.Rhs) == 1 {
+		return handleMultiAssign(out, st, c)
+	}
+	if len(st.Lhs) > 1 {
+		return fmt.Errorf("unsupported # of lhs exprs: %v", st.Lhs)
+	}
+	if len(st.Rhs) > 1 {
+		return fmt.Errorf("unsupported # of rhs exprs: %v", st.Rhs)
+	}
+	if id, ok := st.Lhs[0].(*ast.Ident); ok && id.Name == "_" && st.Tok == token.ASSIGN {
+		// The value is discarded; the cast keeps C++ compilers from
+		// warning about it.
+		fmt.Fprint(out, "(void)")
+		return handleOperand(out, st.Rhs[0], unaryPrec, true)
+	}
+	if t := typeFromExpr(out, st.Lhs[0]); out.vtable() && st.Tok == token.ASSIGN && isInterface(out, ast.NewIdent(t)) {
+		return handleInterfaceAssign(out, st, t)
+	}
+	if st.Tok == token.DEFINE {
+		name, ok := st.Lhs[0].(*ast.Ident)
+		if !ok {
+			return fmt.Errorf("unsupported left expr: %v", st.Lhs[0])
+		}
+		if cl, ok := st.Rhs[0].(*ast.CompositeLit); ok {
+			if _, ok := sliceLit(out, cl); ok {
+				return handleSliceLitDecl(out, name, cl)
+			}
+		}
+		if se, ok := arraySlice(out, st.Rhs[0]); ok {
+			// The statement is terminated by the caller.
+			var buf bytes.Buffer
+			if err := handleArraySlice(out.to(&buf), name, se); err != nil {
+				return err
+			}
+			fmt.Fprint(out, strings.TrimSuffix(buf.String(), ";\n"))
+			return nil
+		}
+		typ := typeFromExpr(out, st.Rhs[0])
+		if typ == "" {
+			if err := valueError(out, st.Rhs[0]); err != nil {
+				return err
+			}
+			return fmt.Errorf("cannot infer the type of %s from %#v", name, st.Rhs[0])
+		}
+		out.symbols.define(name.Name, typ)
+		fmt.Fprintf(out, "%s %s = ", typ, name)
+	} else {
+		if err := handleExpr(out, st.Lhs[0]); err != nil {
+			return fmt.Errorf("error handling left expr %v: %v", st.Lhs[0], err)
+		}
+		if st.Tok == token.AND_NOT_ASSIGN {
+			// x &^= y clears the bits of y in x.
+			fmt.Fprint(out, "&=~")
+			return handleOperand(out, st.Rhs[0], unaryPrec, true)
+		}
+		fmt.Fprint(out, st.Tok)
+	}
+	rhs := st.Rhs[0]
+	if st.Tok == token.ASSIGN {
+		rhs = errorValue(out, typeFromExpr(out, st.Lhs[0]), rhs)
+	}
+	if err := handleExpr(out, rhs); err != nil {
+		return fmt.Errorf("error handling right expr %v: %v", st.Rhs[0], err)
+	}
+	return nil
+}
+
+// isAppend reports whether st appends to a variable, as in
+// s = append(s, v).
+func isAppend(st *ast.AssignStmt) bool {
+	if st.Tok != token.ASSIGN || len(st.Lhs) != 1 || len(st.Rhs) != 1 {
+		return false
+	}
+	c, ok := st.Rhs[0].(*ast.CallExpr)
+	if !ok || len(c.Args) == 0 {
+		return false
+	}
+	fun, ok := c.Fun.(*ast.Ident)
+	return ok && fun.Name == "append" && fun.Obj == nil
+}
+
+// handleAppend emits an append to a slice backed by an array, storing each
+// value after the last element. As nothing is reallocated on the MCU, the
+// array must be large enough to hold them all.
+func handleAppend(out *output, st *ast.AssignStmt) error {
+	c := st.Rhs[0].(*ast.CallExpr)
+	s, ok := st.Lhs[0].(*ast.Ident)
+	if !ok || s.Obj == nil || out.lengths[s.Obj] == "" {
+		return unsupported(out, st, "append is only supported to a slice of an array, declared as s := buf[:0] (line %d)", out.line(st))
+	}
+	if arg, ok := c.Args[0].(*ast.Ident); !ok || arg.Name != s.Name {
+		return unsupported(out, st, "append to %s must assign to %s (line %d)", types.ExprString(c.Args[0]), types.ExprString(c.Args[0]), out.line(st))
+	}
+	elem := fmt.Sprintf("%s[%s++]=", s.Name, out.lengths[s.Obj])
+	values := c.Args[1:]
+	for i, v := range values {
+		if i > 0 {
+			fmt.Fprint(out, out.indentStr)
+		}
+		if c.Ellipsis.IsValid() && i == len(values)-1 {
+			// All the elements of the last value are appended.
+			var src, length bytes.Buffer
+			if err := handleOperand(out.to(&src), v, unaryPrec+1, false); err != nil {
+				return fmt.Errorf("error handling appended value %v: %v", types.ExprString(v), err)
+			}
+			if err := handleBuiltinCall(out.to(&length), "len", &ast.CallExpr{Fun: ast.NewIdent("len"), Args: []ast.Expr{v}}); err != nil {
+				return fmt.Errorf("error handling length of appended value %v: %v", types.ExprString(v), err)
+			}
+			fmt.Fprintf(out, "for (%s __mugo_i = 0; __mugo_i<%s; __mugo_i++) {\n", tokenStr(out, token.INT), length.String())
+			fmt.Fprintf(out, "%s%s%s[__mugo_i];\n}\n", out.indentStr, elem, src.String())
+			continue
+		}
+		fmt.Fprint(out, elem)
+		if err := handleExpr(out, v); err != nil {
+			return fmt.Errorf("error handling appended value %v: %v", types.ExprString(v), err)
+		}
+		fmt.Fprint(out, ";\n")
+	}
+	if len(values) == 0 {
+		// Appending nothing is a no-op.
+		fmt.Fprint(out, ";\n")
+	}
+	return nil
+}
+
+// handleMultiReturn returns multiple values in the result struct of the
+// current function.
+func handleMultiReturn(out *output, st *ast.ReturnStmt) error {
+	if out.results == "" {
+		return fmt.Errorf("unexpected # of return values: %v", st.Results)
+	}
+	results := make([]ast.Expr, len(st.Results))
+	for i, e := range st.Results {
+		results[i] = returnValue(out, i, e)
+	}
+	values, err := exprList(out, results)
+	if err != nil {
+		return fmt.Errorf("error handling return values: %v", err)
+	}
+	if out.features.braceInit {
+		fmt.Fprintf(out, "return {%s};\n", values)
+		return nil
+	}
+	tmp := out.tmp()
+	fmt.Fprintf(out, "%s %s = {%s};\n", out.results, tmp, values)
+	fmt.Fprintf(out, "%sreturn %s;\n", out.indentStr, tmp)
+	return nil
+}
+
+// handleMultiAssign assigns the values returned by c, a call to a function
+// returning multiple values, to the left hand side of st.
+func handleMultiAssign(out *output, st *ast.AssignStmt, c *ast.CallExpr) error {
+	fd := calledFunc(out, c)
+	if fd == nil {
+		return fmt.Errorf("cannot find the declaration of %v returning multiple values", c.Fun)
+	}
+	results, err := resultTypes(out, fd.Type)
+	if err != nil {
+		return fmt.Errorf("unsupported return type: %v", err)
+	}
+	if len(results) != len(st.Lhs) {
+		return fmt.Errorf("assignment mismatch: %d variables but %v returns %d values", len(st.Lhs), c.Fun, len(results))
+	}
+	var call bytes.Buffer
+	if err := handleCallExpr(out.to(&call), c); err != nil {
+		return err
+	}
+	typ := "auto"
+	if !out.features.auto {
+		name, err := funcName(fd)
+		if err != nil {
+			return err
+		}
+		typ = name + "_result"
+	}
+	tmp := out.tmp()
+	fmt.Fprintf(out, "%s %s = %s", typ, tmp, call.String())
+	for i, lhs := range st.Lhs {
+		id, isIdent := lhs.(*ast.Ident)
+		if isIdent && id.Name == "_" {
+			continue
+		}
+		fmt.Fprintf(out, ";\n%s", out.indentStr)
+		if isIdent && st.Tok == token.DEFINE && id.Obj != nil && id.Obj.Decl == st {
+			out.symbols.define(id.Name, results[i])
+			fmt.Fprintf(out, "%s %s = ", results[i], id.Name)
+		} else {
+			if err := handleExpr(out, lhs); err != nil {
+				return fmt.Errorf("error handling left expr %v: %v", lhs, err)
+			}
+			fmt.Fprint(out, " = ")
 		}
+		fmt.Fprintf(out, "%s.r%d", tmp, i)
 	}
 	return nil
 }
 
-func handleDecl(out io.Writer, d ast.Decl) error {
-	switch decl := d.(type) {
-	case *ast.GenDecl:
-		return handleGenDecl(out, decl)
-	case *ast.FuncDecl:
-		return handleFuncDecl(out, decl)
+// calledFunc returns the declaration of the function or method called by c,
+// or nil if it is not declared in the transpiled file.
+func calledFunc(out *output, c *ast.CallExpr) *ast.FuncDecl {
+	switch fun := c.Fun.(type) {
+	case *ast.Ident:
+		return out.funcs[fun.Name]
+	case *ast.SelectorExpr:
+		_, m := methodDecl(out, fun)
+		return m
 	default:
-		return fmt.Errorf("unsupported decl: %#v", d)
+		return nil
 	}
 }
 
-func handleGenDecl(out io.Writer, gd *ast.GenDecl) error {
-	for _, s := range gd.Specs {
-		vs, ok := s.(*ast.ValueSpec)
-		if !ok {
-			return fmt.Errorf("unsupported spec: %#v", s)
-		}
-		if len(vs.Names) > 1 {
-			return fmt.Errorf("unsupported # of value names: %v", vs.Names)
-		}
-		decl := []string{}
-		if vs.Names[0].Obj.Kind == ast.Con {
-			decl = append(decl, "const")
+// exprList returns the comma separated list of the given expressions.
+func exprList(out *output, exprs []ast.Expr) (string, error) {
+	list := []string{}
+	for _, e := range exprs {
+		var buf bytes.Buffer
+		if err := handleExpr(out.to(&buf), e); err != nil {
+			return "", fmt.Errorf("error handling expr %#v: %v", e, err)
 		}
-		if len(vs.Values) > 1 {
-			return fmt.Errorf("unsupported # of values: %v", vs.Names)
+		list = append(list, buf.String())
+	}
+	return strings.Join(list, ", "), nil
+}
+
+func handleForStmt(out *output, fs *ast.ForStmt) error {
+	var init, cond, post bytes.Buffer
+	if fs.Init != nil {
+		if err := handleSimpleStmt(out.to(&init), fs.Init); err != nil {
+			return fmt.Errorf("error handling for loop init statement: %v", err)
 		}
-		l, ok := vs.Values[0].(*ast.BasicLit)
-		if !ok {
-			return fmt.Errorf("unsupported value: %#v", vs.Values[0])
+	}
+	if fs.Cond != nil {
+		if err := handleExpr(out.to(&cond), fs.Cond); err != nil {
+			return fmt.Errorf("error handling for loop condition: %v", err)
 		}
-		if l.Kind != token.INT {
-			return fmt.Errorf("unsupported literal kind: %#v", l.Kind)
+	}
+	if fs.Post != nil {
+		if err := handleSimpleStmt(out.to(&post), fs.Post); err != nil {
+			return fmt.Errorf("error handling for loop post statement: %v", err)
 		}
-		decl = append(decl, "int", vs.Names[0].Name, "=", l.Value)
-		fmt.Fprintf(out, "%s;\n", strings.Join(decl, " "))
 	}
+	fmt.Fprintf(out, "for (%s; %s; %s) {\n", init.String(), cond.String(), post.String())
+	if err := handleBlockStmt(out, fs.Body); err != nil {
+		return fmt.Errorf("error handling for block statements: %v", err)
+	}
+	fmt.Fprintln(out, "}")
 	return nil
 }
 
-func handleFuncDecl(out io.Writer, fd *ast.FuncDecl) error {
-	if fd.Type.Results != nil {
-		return fmt.Errorf("unsupported return type: %#v", fd.Type.Results)
+// handleRangeStmt emits a range loop over an array as a for loop over its
+// indices, declaring the value variable at the start of the body.
+func handleRangeStmt(out *output, rs *ast.RangeStmt) error {
+	at := rangeArray(rs.X)
+	if at == nil || rs.Tok == token.ASSIGN {
+		return unsupported(out, rs, "unsupported range over %s (line %d); only arrays can be ranged over", types.ExprString(rs.X), out.line(rs))
 	}
-	if fd.Type.Params.List != nil {
-		return fmt.Errorf("unsupported param type: %#v", fd.Type.Params)
+	elem, err := exprTypeToType(out, at.Elt)
+	if err != nil {
+		return fmt.Errorf("error handling range element type: %v", err)
 	}
-	fmt.Fprintf(out, "void %s() {\n", fd.Name)
-	if err := handleBlockStmt(out, fd.Body); err != nil {
-		return fmt.Errorf("error handling block statement for %q: %v", fd.Name, err)
+	var x bytes.Buffer
+	if err := handleExpr(out.to(&x), rs.X); err != nil {
+		return fmt.Errorf("error handling range expression %v: %v", types.ExprString(rs.X), err)
+	}
+	arr := x.String()
+	value, _ := rs.Value.(*ast.Ident)
+	if value != nil && value.Name == "_" {
+		value = nil
+	}
+	n, constLen := constArrayLen(at)
+	if _, ok := rs.X.(*ast.CompositeLit); ok && (value != nil || !constLen) {
+		// Array literals are declared before the loop to be indexed, or
+		// to be measured when their length is implicit.
+		decls := out.prelude
+		if decls == nil {
+			return unsupported(out, rs, "unsupported range over an array literal (line %d)", out.line(rs))
+		}
+		arr = fmt.Sprintf("__mugo_buf%d", *out.tmps)
+		*out.tmps++
+		fmt.Fprintf(decls, "%s %s[] = %s;\n", elem, arr, x.String())
+	}
+	length := fmt.Sprintf("(sizeof(%[1]s)/sizeof(%[1]s[0]))", arr)
+	if constLen {
+		length = strconv.Itoa(n)
+	}
+	i := "__mugo_i"
+	intType := tokenStr(out, token.INT)
+	if id, ok := rs.Key.(*ast.Ident); ok && id.Name != "_" {
+		i = id.Name
+		out.symbols.define(i, intType)
+	}
+	fmt.Fprintf(out, "for (%[1]s %[2]s = 0; %[2]s<%[3]s; %[2]s++) {\n", intType, i, length)
+	if value != nil {
+		out.symbols.define(value.Name, elem)
+		fmt.Fprintf(out, "%s%s %s = %s[%s];\n", out.indentStr, elem, value.Name, arr, i)
+	}
+	if err := handleBlockStmt(out, rs.Body); err != nil {
+		return fmt.Errorf("error handling range block statements: %v", err)
 	}
 	fmt.Fprintln(out, "}")
 	return nil
 }
 
-func handleBlockStmt(out io.Writer, bs *ast.BlockStmt) error {
-	for _, s := range bs.List {
-		fmt.Fprintf(out, "  ")
-		switch st := s.(type) {
-		case *ast.ExprStmt:
-			if err := handleExpr(out, st.X); err != nil {
-				return fmt.Errorf("error handling expr stmt %v: %v", st.X, err)
+// rangeArray returns the type of the array e, which is either an array
+// literal or a variable declared as an array, or nil if it is not known.
+func rangeArray(e ast.Expr) *ast.ArrayType {
+	switch x := e.(type) {
+	case *ast.CompositeLit:
+		if at, ok := x.Type.(*ast.ArrayType); ok && at.Len != nil {
+			return at
+		}
+	case *ast.Ident:
+		if x.Obj == nil {
+			return nil
+		}
+		switch d := x.Obj.Decl.(type) {
+		case *ast.ValueSpec:
+			if at, ok := d.Type.(*ast.ArrayType); ok && at.Len != nil {
+				return at
 			}
-			fmt.Fprint(out, ";\n")
-		case *ast.AssignStmt:
-			if len(st.Lhs) > 1 {
-				return fmt.Errorf("unsupported # of lhs exprs: %v", st.Lhs)
-
+			for i, n := range d.Names {
+				if n.Name == x.Name && i < len(d.Values) {
+					return rangeArray(d.Values[i])
+				}
 			}
-			if err := handleExpr(out, st.Lhs[0]); err != nil {
-				return fmt.Errorf("error handling left expr %v: %v", st.Lhs[0], err)
+		case *ast.AssignStmt:
+			for i, n := range d.Lhs {
+				if id, ok := n.(*ast.Ident); ok && id.Name == x.Name && len(d.Lhs) == len(d.Rhs) {
+					return rangeArray(d.Rhs[i])
+				}
 			}
-			fmt.Fprintf(out, "=")
-			if len(st.Rhs) > 1 {
-				return fmt.Errorf("unsupported # of rhs exprs: %v", st.Rhs)
+		}
+	}
+	return nil
+}
+
+// constArrayLen returns the length of the array type e if it is written as
+// an integer literal, which is known without a sizeof expression.
+func constArrayLen(e ast.Expr) (int, bool) {
+	at, ok := e.(*ast.ArrayType)
+	if !ok {
+		return 0, false
+	}
+	lit, ok := at.Len.(*ast.BasicLit)
+	if !ok || lit.Kind != token.INT {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(lit.Value, 0, 0)
+	if err != nil {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// typeFromExpr returns the C++ type of e, or "" if it cannot be inferred.
+func typeFromExpr(out *output, e ast.Expr) string {
+	if t := inferType(out.probe(), e); t != "" {
+		return t
+	}
+	return checkedType(out, e)
+}
 
+// inferType returns the C++ type of e as far as it can be inferred from the
+// syntax of e and the declarations of the file, or "".
+func inferType(out *output, e ast.Expr) string {
+	switch expr := e.(type) {
+	case *ast.BasicLit:
+		return tokenStr(out, expr.Kind)
+	case *ast.Ident:
+		if expr.Name == "true" || expr.Name == "false" {
+			return "bool"
+		}
+		if isIota(expr) {
+			return tokenStr(out, token.INT)
+		}
+		typ, _ := out.symbols.lookup(expr.Name)
+		return typ
+	case *ast.ParenExpr:
+		return typeFromExpr(out, expr.X)
+	case *ast.CompositeLit:
+		if expr.Type == nil {
+			return ""
+		}
+		t, err := exprTypeToType(out, expr.Type)
+		if err != nil {
+			return ""
+		}
+		return t
+	case *ast.BinaryExpr:
+		switch expr.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+			return "bool"
+		case token.SHL, token.SHR:
+			return typeFromExpr(out, expr.X)
+		}
+		if t := typeFromExpr(out, expr.X); t != "" {
+			return t
+		}
+		return typeFromExpr(out, expr.Y)
+	case *ast.StarExpr:
+		t := typeFromExpr(out, expr.X)
+		if !strings.HasSuffix(t, "*") {
+			return ""
+		}
+		return strings.TrimSpace(strings.TrimSuffix(t, "*"))
+	case *ast.UnaryExpr:
+		t := typeFromExpr(out, expr.X)
+		switch {
+		case t == "":
+			return ""
+		case expr.Op == token.AND:
+			return t + "*"
+		case expr.Op == token.NOT:
+			return "bool"
+		default:
+			return t
+		}
+	case *ast.IndexExpr:
+		t := typeFromExpr(out, expr.X)
+		switch {
+		case t == basicTypes["string"]:
+			return basicTypes["byte"]
+		case strings.HasSuffix(t, "]"):
+			return t[:strings.Index(t, "[")] + t[strings.Index(t, "]")+1:]
+		case strings.HasSuffix(t, "*"):
+			return strings.TrimSpace(strings.TrimSuffix(t, "*"))
+		}
+		return ""
+	case *ast.SliceExpr:
+		return typeFromExpr(out, expr.X)
+	case *ast.CallExpr:
+		if at, ok := expr.Fun.(*ast.ArrayType); ok {
+			t, err := exprTypeToType(out, at)
+			if err != nil {
+				return ""
 			}
-			if err := handleExpr(out, st.Rhs[0]); err != nil {
-				return fmt.Errorf("error handling right expr %v: %v", st.Rhs[0], err)
+			return t
+		}
+		if isErrorf(expr) {
+			return errorType(out)
+		}
+		if sel, ok := expr.Fun.(*ast.SelectorExpr); ok && out.vtable() {
+			if t := interfaceResult(out, sel); t != "" {
+				return t
 			}
-			fmt.Fprint(out, ";\n")
-		case *ast.IfStmt:
-			fmt.Fprintf(out, "if (")
-			if err := handleExpr(out, st.Cond); err != nil {
-				return fmt.Errorf("error handling if block conditionx: %v", err)
+		}
+		if fd := calledFunc(out, expr); fd != nil {
+			results, err := resultTypes(out, fd.Type)
+			if err != nil || len(results) != 1 {
+				return ""
 			}
-			fmt.Fprint(out, ") {\n")
-			if err := handleBlockStmt(out, st.Body); err != nil {
-				return fmt.Errorf("error handling if block statements: %v", err)
+			return results[0]
+		}
+		id, ok := expr.Fun.(*ast.Ident)
+		if !ok || id.Obj != nil {
+			return ""
+		}
+		switch id.Name {
+		case "len", "cap":
+			return tokenStr(out, token.INT)
+		case "new":
+			if len(expr.Args) != 1 {
+				return ""
 			}
-			fmt.Fprintf(out, "}")
-			if st.Else != nil {
-				bs, ok := st.Else.(*ast.BlockStmt)
-				if !ok {
-					return fmt.Errorf("unsupported statement: %v", st.Else)
-				}
-				fmt.Fprintf(out, " else {\n")
-				if err := handleBlockStmt(out, bs); err != nil {
-					return fmt.Errorf("error handling else block statements: %v", err)
-				}
-				fmt.Fprintf(out, "}")
+			t, err := exprTypeToType(out, expr.Args[0])
+			if err != nil {
+				return ""
 			}
-			fmt.Fprintln(out)
-		default:
-			return fmt.Errorf("unsupported statement: %v", s)
-
+			return t + "*"
 		}
+		return ""
+	default:
+		return ""
 	}
-	return nil
 }
 
-func handleCallExpr(out io.Writer, c *ast.CallExpr) error {
-	funcName, ok := c.Fun.(*ast.Ident)
-	if !ok {
-		return fmt.Errorf("unsupported func expr: %#v", c.Fun)
+// tokenStr returns the C++ type of a literal of the given kind.
+func tokenStr(out *output, kind token.Token) string {
+	switch kind {
+	case token.INT:
+		t, _ := out.basicType("int")
+		return t
+	case token.FLOAT:
+		return "double"
+	case token.CHAR:
+		return "char"
+	case token.STRING:
+		return "const char *"
+	default:
+		return ""
 	}
+}
+
+func handleCallExpr(out *output, c *ast.CallExpr) error {
+	var funcName string
 	args := []string{}
-	for _, a := range c.Args {
+	if isErrorf(c) {
+		return handleErrorf(out, c)
+	}
+	if isPrint(c) {
+		return handlePrint(out, c)
+	}
+	switch fun := c.Fun.(type) {
+	case *ast.Ident:
+		if fun.Obj == nil && builtins[fun.Name] {
+			return handleBuiltinCall(out, fun.Name, c)
+		}
+		if !out.features.cpp && isConversion(out, fun) && len(c.Args) == 1 {
+			// C has no function-style casts.
+			return handleCast(out, fun, c.Args[0])
+		}
+		funcName = fun.Name
+	case *ast.ArrayType:
+		// Conversions to slices, such as []byte(s), are pointer casts.
+		if fun.Len != nil || len(c.Args) != 1 {
+			return unsupported(out, c, "unsupported conversion to %s (line %d)", types.ExprString(fun), out.line(c))
+		}
+		t, err := exprTypeToType(out, fun)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "(%s)", t)
+		return handleOperand(out, c.Args[0], unaryPrec, true)
+	case *ast.SelectorExpr:
+		if out.vtable() && isInterface(out, ast.NewIdent(typeFromExpr(out, fun.X))) {
+			return handleInterfaceCall(out, fun, c)
+		}
+		if name, recv, ok := lookupMethod(out, fun); ok {
+			funcName = name
+			args = append(args, recv)
+			break
+		}
+		if x, ok := fun.X.(*ast.Ident); ok && x.Obj == nil && out.imports[x.Name].lowered {
+			return unsupported(out, c, "unsupported call to %s (line %d)", types.ExprString(fun), out.line(c))
+		}
+		var buf bytes.Buffer
+		if err := handleSelectorExpr(out.to(&buf), fun); err != nil {
+			return fmt.Errorf("error handling func expr %#v: %v", fun, err)
+		}
+		funcName = buf.String()
+	default:
+		return unsupported(out, c.Fun, "unsupported func expr %T (line %d)", c.Fun, out.line(c.Fun))
+	}
+	params := paramTypes(calledFunc(out, c))
+	for i, a := range c.Args {
+		if i < len(params) && out.vtable() && isInterface(out, params[i]) {
+			v, err := interfaceArg(out, params[i].(*ast.Ident).Name, a)
+			if err != nil {
+				return fmt.Errorf("error handling func arg expr %v: %v", types.ExprString(a), err)
+			}
+			args = append(args, v)
+			continue
+		}
 		var buf bytes.Buffer
-		if err := handleExpr(&buf, a); err != nil {
+		if err := handleExpr(out.to(&buf), a); err != nil {
 			return fmt.Errorf("error handling func arg expr %#v: %v", a, err)
 		}
 		args = append(args, buf.String())
@@ -176,18 +2552,237 @@ func handleCallExpr(out io.Writer, c *ast.CallExpr) error {
 	return nil
 }
 
-func handleBinaryExpr(out io.Writer, be *ast.BinaryExpr) error {
-	if err := handleExpr(out, be.X); err != nil {
+// isConversion reports whether calling id converts to the type it names.
+func isConversion(out *output, id *ast.Ident) bool {
+	if id.Obj != nil {
+		return id.Obj.Kind == ast.Typ
+	}
+	_, ok := out.basicType(id.Name)
+	return ok
+}
+
+// handleCast emits the conversion of e to the type named by id as a C cast.
+func handleCast(out *output, id *ast.Ident, e ast.Expr) error {
+	t, err := exprTypeToType(out, id)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := handleExpr(out.to(&buf), e); err != nil {
+		return fmt.Errorf("error handling the conversion of %s to %s: %v", types.ExprString(e), id, err)
+	}
+	fmt.Fprintf(out, "(%s)(%s)", t, buf.String())
+	return nil
+}
+
+// builtins lists the predeclared functions needing special handling.
+var builtins = map[string]bool{
+	"cap":    true,
+	"copy":   true,
+	"delete": true,
+	"len":    true,
+	"make":   true,
+	"new":    true,
+}
+
+func handleBuiltinCall(out *output, name string, c *ast.CallExpr) error {
+	switch name {
+	case "len", "cap":
+		if len(c.Args) != 1 {
+			return fmt.Errorf("unsupported # of %s args: %v", name, c.Args)
+		}
+		var buf bytes.Buffer
+		if err := handleExpr(out.to(&buf), c.Args[0]); err != nil {
+			return fmt.Errorf("error handling %s arg %#v: %v", name, c.Args[0], err)
+		}
+		if id, ok := c.Args[0].(*ast.Ident); ok && name == "len" && id.Obj != nil && out.lengths[id.Obj] != "" {
+			fmt.Fprint(out, out.lengths[id.Obj])
+			return nil
+		}
+		if name == "len" && typeFromExpr(out, c.Args[0]) == "const char *" {
+			fmt.Fprintf(out, "strlen(%s)", buf.String())
+			return nil
+		}
+		fmt.Fprintf(out, "(sizeof(%[1]s)/sizeof(%[1]s[0]))", buf.String())
+		return nil
+	case "new":
+		return handleNew(out, c)
+	case "copy":
+		return handleBuiltinCopy(out, c)
+	case "make":
+		return handleBuiltinMake(out, c)
+	case "delete":
+		return unsupported(out, c, "delete() requires a map, which is not supported on MCU targets (line %d); consider a manual removal from a fixed-size struct-based table", out.line(c))
+	default:
+		return unsupported(out, c, "unsupported builtin %s (line %d)", name, out.line(c))
+	}
+}
+
+// handleBuiltinMake reports the values make creates, which are allocated
+// dynamically, as unsupported with a suggestion depending on their type.
+func handleBuiltinMake(out *output, c *ast.CallExpr) error {
+	if len(c.Args) == 0 {
+		return fmt.Errorf("missing type of make (line %d)", out.line(c))
+	}
+	switch c.Args[0].(type) {
+	case *ast.ChanType:
+		return unsupported(out, c, "channel creation is not supported on MCU targets (line %d); consider a shared volatile variable with an interrupt flag", out.line(c))
+	case *ast.MapType:
+		return unsupported(out, c, "map creation is not supported on MCU targets (line %d); consider a fixed-size array of key and value structs", out.line(c))
+	case *ast.ArrayType:
+		return unsupported(out, c, "slice creation with make is not supported on MCU targets (line %d); consider declaring an array with a constant length", out.line(c))
+	default:
+		return unsupported(out, c, "unsupported make of %s (line %d)", types.ExprString(c.Args[0]), out.line(c))
+	}
+}
+
+// valueError returns the error handling e gives, if any, which explains
+// why e cannot be transpiled better than its type not being known.
+func valueError(out *output, e ast.Expr) error {
+	o := out.to(io.Discard)
+	// Nor is what would precede the statement kept.
+	if o.before != nil {
+		o.before = &bytes.Buffer{}
+	}
+	if o.prelude != nil {
+		o.prelude = &bytes.Buffer{}
+	}
+	return handleExpr(o, e)
+}
+
+// handleBuiltinCopy emits copy(dst, src) as a memcpy of the shorter of the
+// two, whose lengths must be known: dst must be an array and src an array or
+// a string.
+func handleBuiltinCopy(out *output, c *ast.CallExpr) error {
+	if len(c.Args) != 2 {
+		return fmt.Errorf("unsupported # of copy args: %v", c.Args)
+	}
+	args := make([]string, 2)
+	lens := make([]string, 2)
+	for i, a := range c.Args {
+		var buf bytes.Buffer
+		if err := handleExpr(out.to(&buf), a); err != nil {
+			return fmt.Errorf("error handling copy arg %#v: %v", a, err)
+		}
+		args[i] = buf.String()
+		switch t := typeFromExpr(out, a); {
+		case strings.HasSuffix(t, "]"):
+			lens[i] = fmt.Sprintf("(sizeof(%[1]s)/sizeof(%[1]s[0]))", args[i])
+		case t == basicTypes["string"] && i == 1:
+			lens[i] = fmt.Sprintf("strlen(%s)", args[i])
+		default:
+			return unsupported(out, a, "copy of %s whose length is unknown (line %d)", args[i], out.line(a))
+		}
+	}
+	fmt.Fprintf(out, "memcpy(%[1]s, %[2]s, (%[3]s < %[4]s ? %[3]s : %[4]s) * sizeof(%[1]s[0]))", args[0], args[1], lens[0], lens[1])
+	return nil
+}
+
+// binaryOp is the C++ spelling of a Go binary operator.
+type binaryOp struct {
+	op string
+	// prec is the C++ precedence of op, higher binding tighter.
+	prec int
+}
+
+// unaryPrec is the C++ precedence of unary operators.
+const unaryPrec = 11
+
+// binaryOps maps Go binary operators to their C++ equivalent. The bit clear
+// operator &^ has none and is emitted as x & ~y.
+var binaryOps = map[token.Token]binaryOp{
+	token.MUL:     {"*", 10},
+	token.QUO:     {"/", 10},
+	token.REM:     {"%", 10},
+	token.ADD:     {"+", 9},
+	token.SUB:     {"-", 9},
+	token.SHL:     {"<<", 8},
+	token.SHR:     {">>", 8},
+	token.LSS:     {"<", 7},
+	token.LEQ:     {"<=", 7},
+	token.GTR:     {">", 7},
+	token.GEQ:     {">=", 7},
+	token.EQL:     {"==", 6},
+	token.NEQ:     {"!=", 6},
+	token.AND:     {"&", 5},
+	token.AND_NOT: {"&", 5},
+	token.XOR:     {"^", 4},
+	token.OR:      {"|", 3},
+	token.LAND:    {"&&", 2},
+	token.LOR:     {"||", 1},
+}
+
+func handleBinaryExpr(out *output, be *ast.BinaryExpr) error {
+	if be.Op == token.EQL || be.Op == token.NEQ {
+		be = compareError(out, be)
+	}
+	if be.Op == token.ADD && typeFromExpr(out, be.X) == basicTypes["string"] && typeFromExpr(out, be.Y) == basicTypes["string"] {
+		return handleStringConcat(out, be)
+	}
+	op, ok := binaryOps[be.Op]
+	if !ok {
+		return unsupported(out, be, "unsupported binary operator %v (line %d)", be.Op, out.line(be))
+	}
+	if err := handleOperand(out, be.X, op.prec, false); err != nil {
 		return fmt.Errorf("error handling left part %v of binary expr: %v", be.X, err)
 	}
-	fmt.Fprint(out, be.Op)
-	if err := handleExpr(out, be.Y); err != nil {
+	fmt.Fprint(out, op.op)
+	prec := op.prec
+	if be.Op == token.AND_NOT {
+		fmt.Fprint(out, "~")
+		prec = unaryPrec
+	}
+	if err := handleOperand(out, be.Y, prec, true); err != nil {
 		return fmt.Errorf("error handling right part %v of binary expr: %v", be.Y, err)
 	}
 	return nil
 }
 
-func handleUnaryExpr(out io.Writer, ue *ast.UnaryExpr) error {
+// handleStringConcat emits the concatenation of two strings, which are
+// pointers in C++, as a call to a helper allocating the result.
+func handleStringConcat(out *output, be *ast.BinaryExpr) error {
+	out.helpers[strcatHelper] = true
+	fmt.Fprintf(out, "%s(", strcatHelper)
+	if err := handleExpr(out, be.X); err != nil {
+		return err
+	}
+	fmt.Fprint(out, ", ")
+	if err := handleExpr(out, be.Y); err != nil {
+		return err
+	}
+	fmt.Fprint(out, ")")
+	return nil
+}
+
+// handleOperand emits an operand of an operator of the given precedence,
+// adding parentheses where the C++ precedence rules, which differ from Go's
+// for shifts and bitwise operators, would change its meaning.
+func handleOperand(out *output, e ast.Expr, prec int, right bool) error {
+	be, ok := e.(*ast.BinaryExpr)
+	if !ok {
+		return handleExpr(out, e)
+	}
+	op, ok := binaryOps[be.Op]
+	if !ok || op.prec > prec || op.prec == prec && !right {
+		return handleExpr(out, e)
+	}
+	fmt.Fprint(out, "(")
+	if err := handleExpr(out, e); err != nil {
+		return err
+	}
+	fmt.Fprint(out, ")")
+	return nil
+}
+
+func handleUnaryExpr(out *output, ue *ast.UnaryExpr) error {
+	if ue.Op == token.ARROW {
+		return unsupported(out, ue, "channel receive is not supported on MCU targets (line %d); replace with a direct function call or a shared variable with volatile qualifier", out.line(ue))
+	}
+	if out.opts.StrictMode && ue.Op == token.NOT {
+		if t := typeFromExpr(out, ue.X); t != "" && t != "bool" {
+			return fmt.Errorf("operator ! not defined on %v of type %s (line %d)", ue.X, t, out.line(ue))
+		}
+	}
 	fmt.Fprint(out, ue.Op)
 	if err := handleExpr(out, ue.X); err != nil {
 		return err
@@ -195,17 +2790,285 @@ func handleUnaryExpr(out io.Writer, ue *ast.UnaryExpr) error {
 	return nil
 }
 
-func handleIdent(out io.Writer, ident *ast.Ident) error {
-	fmt.Fprintf(out, ident.Name)
+// lookupMethod resolves a method call on a variable to the C++ function
+// implementing it and the receiver argument to pass.
+func lookupMethod(out *output, sel *ast.SelectorExpr) (string, string, bool) {
+	typ, m := methodDecl(out, sel)
+	if m == nil {
+		return "", "", false
+	}
+	base := strings.TrimSuffix(typ, "*")
+	_, pointerRecv, _ := receiverType(m)
+	recv := sel.X.(*ast.Ident).Name
+	if isPointer := base != typ; pointerRecv && !isPointer {
+		recv = "&" + recv
+	} else if !pointerRecv && isPointer {
+		recv = "*" + recv
+	}
+	return methodName(base, sel.Sel.Name), recv, true
+}
+
+// methodDecl returns the C++ type of the variable a method is called on and
+// the declaration of the method, or nil if sel is not a method call on a
+// variable.
+func methodDecl(out *output, sel *ast.SelectorExpr) (string, *ast.FuncDecl) {
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return "", nil
+	}
+	typ, ok := out.symbols.lookup(x.Name)
+	if !ok {
+		return "", nil
+	}
+	return typ, out.methods[strings.TrimSuffix(typ, "*")][sel.Sel.Name]
+}
+
+func handleSelectorExpr(out *output, se *ast.SelectorExpr) error {
+	if name, ok := importedName(out, se); ok {
+		fmt.Fprint(out, name)
+		return nil
+	}
+	if err := handleExpr(out, se.X); err != nil {
+		return err
+	}
+	sep := "."
+	if x, ok := se.X.(*ast.Ident); ok {
+		if typ, _ := out.symbols.lookup(x.Name); strings.HasSuffix(typ, "*") {
+			sep = "->"
+		}
+	}
+	fmt.Fprintf(out, "%s%s", sep, se.Sel.Name)
+	return nil
+}
+
+func handleCompositeLit(out *output, cl *ast.CompositeLit) error {
+	if cl.Type != nil {
+		// The type is not emitted, but must be supported.
+		if _, err := exprTypeToType(out, cl.Type); err != nil {
+			return err
+		}
+	}
+	elts := []string{}
+	for _, e := range sortKeyedElts(out, cl) {
+		var buf bytes.Buffer
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				return unsupported(out, e, "unsupported keyed element (line %d)", out.line(e))
+			}
+			// Named fields use designated initializers.
+			fmt.Fprintf(&buf, ".%s = ", key.Name)
+			e = kv.Value
+		}
+		if err := handleExpr(out.to(&buf), e); err != nil {
+			return fmt.Errorf("error handling element %#v: %v", e, err)
+		}
+		elts = append(elts, buf.String())
+	}
+	if len(elts) == 0 {
+		fmt.Fprint(out, emptyInit(out))
+		return nil
+	}
+	fmt.Fprintf(out, "{%s}", strings.Join(elts, ", "))
+	return nil
+}
+
+// sliceLit returns the C++ type of the elements of cl if it is a slice
+// literal, such as []byte{0x01, 0x02}.
+func sliceLit(out *output, cl ast.Expr) (string, bool) {
+	lit, ok := cl.(*ast.CompositeLit)
+	if !ok {
+		return "", false
+	}
+	at, ok := lit.Type.(*ast.ArrayType)
+	if !ok || at.Len != nil {
+		return "", false
+	}
+	elem, err := exprTypeToType(out.probe(), at.Elt)
+	return elem, err == nil
+}
+
+// handleSliceLit emits the slice literal cl as a temporary array, declared
+// before the current statement, or before the current declaration outside
+// of functions.
+func handleSliceLit(out *output, cl *ast.CompositeLit) error {
+	elem, _ := sliceLit(out, cl)
+	decls := out.prelude
+	if decls == nil {
+		decls = out.before
+	}
+	if decls == nil {
+		return unsupported(out, cl, "unsupported slice literal (line %d)", out.line(cl))
+	}
+	var lit bytes.Buffer
+	if err := handleCompositeLit(out.to(&lit), cl); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("__mugo_buf%d", *out.tmps)
+	*out.tmps++
+	fmt.Fprintf(decls, "%s %s[] = %s;\n", elem, name, lit.String())
+	fmt.Fprint(out, name)
+	return nil
+}
+
+// handleSliceLitDecl declares the variable name initialized with the slice
+// literal cl as an array of its elements.
+func handleSliceLitDecl(out *output, name *ast.Ident, cl *ast.CompositeLit) error {
+	elem, _ := sliceLit(out, cl)
+	var lit bytes.Buffer
+	if err := handleCompositeLit(out.to(&lit), cl); err != nil {
+		return fmt.Errorf("error handling value of %s: %v", name, err)
+	}
+	out.symbols.define(name.Name, fmt.Sprintf("%s[%d]", elem, len(cl.Elts)))
+	fmt.Fprintf(out, "%s %s[] = %s", elem, name, lit.String())
+	return nil
+}
+
+// sortKeyedElts returns the elements of cl, with keyed elements of struct
+// literals sorted in the order of the fields of the struct, as C++ requires
+// for designated initializers.
+func sortKeyedElts(out *output, cl *ast.CompositeLit) []ast.Expr {
+	id, ok := cl.Type.(*ast.Ident)
+	if !ok || !isStruct(out, id) {
+		return cl.Elts
+	}
+	index := map[string]int{}
+	for _, f := range out.types[id.Name].Type.(*ast.StructType).Fields.List {
+		for _, n := range f.Names {
+			index[n.Name] = len(index)
+		}
+	}
+	elts := append([]ast.Expr{}, cl.Elts...)
+	sort.SliceStable(elts, func(i, j int) bool {
+		return keyIndex(index, elts[i]) < keyIndex(index, elts[j])
+	})
+	return elts
+}
+
+// keyIndex returns the index of the field e initializes, or -1 if e is not
+// a keyed element.
+func keyIndex(index map[string]int, e ast.Expr) int {
+	kv, ok := e.(*ast.KeyValueExpr)
+	if !ok {
+		return -1
+	}
+	key, ok := kv.Key.(*ast.Ident)
+	if !ok {
+		return -1
+	}
+	return index[key.Name]
+}
+
+func handleStarExpr(out *output, se *ast.StarExpr) error {
+	fmt.Fprint(out, "*")
+	return handleExpr(out, se.X)
+}
+
+func handleIdent(out *output, ident *ast.Ident) error {
+	if isIota(ident) {
+		fmt.Fprint(out, out.iota)
+		return nil
+	}
+	fmt.Fprint(out, ident.Name)
 	return nil
 }
 
-func handleBasicLit(out io.Writer, lit *ast.BasicLit) error {
-	fmt.Fprintf(out, lit.Value)
+// isIota reports whether ident refers to the predeclared iota.
+func isIota(ident *ast.Ident) bool {
+	return ident.Name == "iota" && ident.Obj == nil
+}
+
+func handleBasicLit(out *output, lit *ast.BasicLit) error {
+	if lit.Kind == token.STRING && strings.HasPrefix(lit.Value, "`") {
+		fmt.Fprint(out, rawStringToC(lit.Value))
+		return nil
+	}
+	if lit.Kind == token.STRING {
+		c, err := goStringToC(lit.Value)
+		if err != nil {
+			return fmt.Errorf("invalid string literal %s (line %d): %v", lit.Value, out.line(lit), err)
+		}
+		fmt.Fprint(out, c)
+		return nil
+	}
+	fmt.Fprint(out, lit.Value)
 	return nil
 }
 
-func handleExpr(out io.Writer, e ast.Expr) error {
+// cEscapes maps the bytes with a C escape sequence other than \xNN to it.
+var cEscapes = map[byte]string{
+	'\a': `\a`,
+	'\b': `\b`,
+	'\f': `\f`,
+	'\n': `\n`,
+	'\r': `\r`,
+	'\t': `\t`,
+	'\v': `\v`,
+	'\\': `\\`,
+	'"':  `\"`,
+}
+
+// goStringToC converts an interpreted Go string literal, including its
+// enclosing quotes, to the equivalent C string literal. Go escapes without
+// a C equivalent, such as \u and \U, and characters outside of ASCII are
+// written as the \xNN escapes of their UTF-8 encoding.
+func goStringToC(s string) (string, error) {
+	s, err := strconv.Unquote(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	hex := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if hex && strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			// C hexadecimal escapes take as many digits as follow
+			// them, so the literal is split.
+			buf.WriteString(`""`)
+		}
+		hex = false
+		switch e, ok := cEscapes[c]; {
+		case ok:
+			buf.WriteString(e)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&buf, `\x%02x`, c)
+			hex = true
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String(), nil
+}
+
+// rawStringToC converts a Go raw string literal, including its enclosing
+// backquotes, to the equivalent C string literal.
+func rawStringToC(s string) string {
+	s = strings.TrimPrefix(s, "`")
+	s = strings.TrimSuffix(s, "`")
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch c {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			// Carriage returns are discarded from raw string literals.
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+func handleExpr(out *output, e ast.Expr) error {
+	out.trace(e)
 	switch expr := e.(type) {
 	case *ast.CallExpr:
 		return handleCallExpr(out, expr)
@@ -213,11 +3076,48 @@ func handleExpr(out io.Writer, e ast.Expr) error {
 		return handleBinaryExpr(out, expr)
 	case *ast.UnaryExpr:
 		return handleUnaryExpr(out, expr)
+	case *ast.StarExpr:
+		return handleStarExpr(out, expr)
+	case *ast.SelectorExpr:
+		return handleSelectorExpr(out, expr)
+	case *ast.CompositeLit:
+		if _, ok := sliceLit(out, expr); ok {
+			return handleSliceLit(out, expr)
+		}
+		return handleCompositeLit(out, expr)
 	case *ast.Ident:
 		return handleIdent(out, expr)
 	case *ast.BasicLit:
 		return handleBasicLit(out, expr)
+	case *ast.FuncLit:
+		return handleFuncLit(out, expr)
+	case *ast.IndexExpr:
+		if err := handleExpr(out, expr.X); err != nil {
+			return err
+		}
+		fmt.Fprint(out, "[")
+		if err := handleExpr(out, expr.Index); err != nil {
+			return err
+		}
+		fmt.Fprint(out, "]")
+		return nil
+	case *ast.SliceExpr:
+		// Only slicing a whole array is supported, which is a no-op as
+		// slices are pointers.
+		if expr.Low != nil || expr.High != nil || expr.Max != nil {
+			return unsupported(out, expr, "unsupported slice expression (line %d)", out.line(expr))
+		}
+		return handleExpr(out, expr.X)
+	case *ast.ParenExpr:
+		fmt.Fprint(out, "(")
+		if err := handleExpr(out, expr.X); err != nil {
+			return err
+		}
+		fmt.Fprint(out, ")")
+		return nil
+	case *ast.BadExpr:
+		return syntaxError(out, expr, expr.From, expr.To)
 	default:
-		return fmt.Errorf("unsupported expr: %#v", e)
+		return unsupported(out, e, "unsupported expr %T (line %d)", e, out.line(e))
 	}
 }
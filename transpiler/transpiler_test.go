@@ -2,13 +2,31 @@ package transpiler
 
 import (
 	"bytes"
-	"io/ioutil"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
 
+var (
+	indentStyle = flag.String("indent", "", "indent style used to transpile the golden tests")
+	target      = flag.String("target", "", "target the golden tests are transpiled for")
+	update      = flag.Bool("update", false, "update the .ino golden files with the output")
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	os.Exit(m.Run())
+}
+
 var sketches = []string{
 	"blink",
 	"button",
@@ -17,35 +35,2808 @@ var sketches = []string{
 
 const sketchDir = "../sketches"
 
+var tests = []string{
+	"interfaces",
+	"isr",
+	"language-basics",
+	"language-basics-v2",
+	"multi-return",
+}
+
+const testDir = "../tests"
+
 func TestSketches(t *testing.T) {
-	for _, s := range sketches {
-		g, err := os.Open(filepath.Join(sketchDir, s, s+".go"))
+	runTests(t, sketchDir, sketches)
+}
+
+func TestTests(t *testing.T) {
+	runTests(t, testDir, tests)
+}
+
+// runTests transpiles dir/name/name.go for each of the given names, in
+// parallel subtests, and compares the result with the golden file of
+// -target, or overwrites the latter with -update.
+func runTests(t *testing.T, dir string, names []string) {
+	for _, s := range names {
+		s := s
+		t.Run(s, func(t *testing.T) {
+			t.Parallel()
+			g, err := os.Open(filepath.Join(dir, s, s+".go"))
+			if err != nil {
+				t.Fatalf("failed to open %s.go: %v", s, err)
+			}
+			defer g.Close()
+			golden := goldenFile(dir, s, *target)
+			var out bytes.Buffer
+			if err := Transpile(&out, g, &Options{IndentStyle: *indentStyle, Target: *target}); err != nil {
+				t.Fatalf("failed to transpile %q: %v", s, err)
+			}
+			if *update {
+				if err := os.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Errorf("failed to update %s: %v", golden, err)
+				}
+				return
+			}
+			bs, err := os.ReadFile(golden)
+			if os.IsNotExist(err) && *target != "" {
+				t.Skipf("no golden file for target %s, create it with -update", *target)
+			}
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", golden, err)
+			}
+			ino := string(bs)
+			if nospace(ino) != nospace(out.String()) {
+				t.Errorf("expected:\n%s-- got:\n%s", ino, out.String())
+			}
+		})
+	}
+}
+
+// goldenFile returns the path of the expected output of dir/name/name.go
+// for target. The output for no target is the sketch dir/name/name.ino, and
+// that for a target is kept next to it in name.ino.<target>, which the
+// Arduino IDE does not build along with the sketch.
+func goldenFile(dir, name, target string) string {
+	path := filepath.Join(dir, name, name+".ino")
+	if target != "" {
+		path += "." + target
+	}
+	return path
+}
+
+func nospace(s string) string {
+	s = strings.Replace(s, " ", "", -1)
+	s = strings.Replace(s, "\t", "", -1)
+	s = strings.Replace(s, "\r", "", -1)
+	s = strings.Replace(s, "\n", "", -1)
+	return s
+}
+
+func TestRawStringToC(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"``", `""`},
+		{"`hello`", `"hello"`},
+		{"`hello\\nworld`", `"hello\\nworld"`},
+		{"`hello\nworld`", `"hello\nworld"`},
+		{"`say \"hi\"`", `"say \"hi\""`},
+		{"`a\r\nb`", `"a\nb"`},
+	} {
+		if got := rawStringToC(tt.in); got != tt.want {
+			t.Errorf("rawStringToC(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGoStringToC(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{`""`, `""`},
+		{`"hello"`, `"hello"`},
+		{`"a\tb\r\n"`, `"a\tb\r\n"`},
+		{`"\a\b\f\v\\\""`, `"\a\b\f\v\\\""`},
+		{`"\x41\x7f\000"`, `"A\x7f\x00"`},
+		{`"caf\u00e9"`, `"caf\xc3\xa9"`},
+		{`"\U0001F600!"`, `"\xf0\x9f\x98\x80!"`},
+		{`"\u00e9t\u00e9"`, `"\xc3\xa9t\xc3\xa9"`},
+		{`"\u00e9a"`, `"\xc3\xa9""a"`},
+		{`"é"`, `"\xc3\xa9"`},
+	} {
+		got, err := goStringToC(tt.in)
 		if err != nil {
-			t.Errorf("failed to open %s.go: %v", s, err)
+			t.Errorf("goStringToC(%s) failed: %v", tt.in, err)
 			continue
 		}
-		defer g.Close()
-		bs, err := ioutil.ReadFile(filepath.Join(sketchDir, s, s+".ino"))
+		if got != tt.want {
+			t.Errorf("goStringToC(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInterfaceDispatchErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		opts *Options
+		err  string
+	}{
+		{
+			src: `package main
+type Reader interface { Read() int }
+type a struct {}
+type b struct {}
+func (a) Read() int { return 1 }
+func (b) Read() int { return 2 }
+func loop() {
+	var r Reader = a{}
+	r = b{}
+}`,
+			err: "interface variable r holds values of type a and b",
+		},
+		{
+			src: `package main
+type Reader interface { Read() int }
+type a struct {}
+func (*a) Read() int { return 1 }
+func loop() {
+	var r Reader = &a{}
+	r = &a{}
+}`,
+			err: "interface variable r initialized with the address of a composite literal cannot be reassigned",
+		},
+		{
+			src: `package main
+type Reader interface { Read() int }
+func use(r Reader) {}`,
+			err: "interface Reader is only supported as the type of an initialized variable",
+		},
+		{
+			src:  "package main",
+			opts: &Options{InterfaceDispatch: "virtual"},
+			err:  `unknown interface dispatch "virtual"`,
+		},
+	} {
+		err := Transpile(io.Discard, strings.NewReader(tt.src), tt.opts)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("expected error containing %q, got %v", tt.err, err)
+		}
+	}
+}
+
+func TestTypeFromBuiltinCall(t *testing.T) {
+	out := &output{opts: &Options{}, target: &TargetConfig{}, symbols: newSymbolTable()}
+	out.symbols.define("arr", "int")
+	for _, tt := range []struct {
+		expr, want string
+	}{
+		{"len(arr)", "int"},
+		{"cap(arr)", "int"},
+		{"new(int)", "int*"},
+		{"new(sensor)", "sensor*"},
+		{"unknown(arr)", ""},
+	} {
+		e, err := parser.ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.expr, err)
+		}
+		if got := typeFromExpr(out, e); got != tt.want {
+			t.Errorf("typeFromExpr(%s) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	for _, tt := range []struct {
+		src string
+		err string
+	}{
+		{
+			src: `package main
+func loop() {
+	ch <- 1
+}`,
+			err: "channel send is not supported on MCU targets (line 3)",
+		},
+		{
+			src: `package main
+func loop() {
+	x := 1
+	x = <-ch
+}`,
+			err: "channel receive is not supported on MCU targets (line 4)",
+		},
+		{
+			src: `package main
+func blink() {}
+func loop() {
+	go blink()
+}`,
+			err: "goroutine is not supported on MCU targets (line 4)",
+		},
+		{
+			src: `package main
+func loop() {
+	delete(counts, 3)
+}`,
+			err: "delete() requires a map, which is not supported on MCU targets (line 3)",
+		},
+		{
+			src: `package main
+func loop() {
+	ch := make(chan int)
+}`,
+			err: "channel creation is not supported on MCU targets (line 3)",
+		},
+		{
+			src: `package main
+func loop() {
+	var counts = make(map[int]int, 8)
+}`,
+			err: "map creation is not supported on MCU targets (line 3)",
+		},
+		{
+			src: `package main
+func loop() {
+	buf := make([]byte, 16)
+}`,
+			err: "slice creation with make is not supported on MCU targets (line 3)",
+		},
+		{
+			src: `package main
+//mugo:isr INT0_vect
+func onPress(pin int) {}`,
+			err: "ISR onPress must not have a receiver, parameters or return values",
+		},
+		{
+			src: `package main
+func count(m map[string]int) int {
+	return 0
+}`,
+			err: "map[string]int parameter is unsupported (line 2); consider passing a struct with fixed-size arrays",
+		},
+		{
+			src: `package main
+var counts map[string]int`,
+			err: "map type map[string]int is unsupported (line 2)",
+		},
+	} {
+		err := Transpile(io.Discard, strings.NewReader(tt.src), nil)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("expected error containing %q, got %v", tt.err, err)
+		}
+	}
+}
+
+func TestLargeStructThreshold(t *testing.T) {
+	const src = `package main
+type small struct { pin int }
+type large struct { pin, value, min, max int }
+func read(s small, l large) int { return l.value }
+func clamp(l large) int {
+	l.value = l.min
+	return l.value
+}
+`
+	for _, tt := range []struct {
+		threshold int
+		want      []string
+	}{
+		{0, []string{"int read(small s, const large& l)", "int clamp(large l)"}},
+		{8, []string{"int read(small s, large l)", "int clamp(large l)"}},
+		{1, []string{"int read(const small& s, const large& l)", "int clamp(large l)"}},
+		{-1, []string{"int read(small s, large l)", "int clamp(large l)"}},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), &Options{LargeStructThreshold: tt.threshold}); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(out.String(), w) {
+				t.Errorf("with threshold %d expected %q in:\n%s", tt.threshold, w, out.String())
+			}
+		}
+	}
+}
+
+func TestTranspileBytes(t *testing.T) {
+	for _, s := range sketches {
+		src, err := os.ReadFile(filepath.Join(sketchDir, s, s+".go"))
+		if err != nil {
+			t.Fatalf("failed to read %s.go: %v", s, err)
+		}
+		var want, got bytes.Buffer
+		if err := Transpile(&want, bytes.NewReader(src), nil); err != nil {
+			t.Fatalf("failed to transpile %q: %v", s, err)
+		}
+		f, err := TranspileBytes(&got, src, nil)
 		if err != nil {
-			t.Errorf("failed to read %s.ino: %v", s, err)
+			t.Fatalf("failed to transpile %q from bytes: %v", s, err)
+		}
+		if f == nil || f.Name.Name != "main" {
+			t.Errorf("expected the parsed file of %q, got %v", s, f)
+		}
+		if want.String() != got.String() {
+			t.Errorf("expected:\n%s-- got:\n%s", want.String(), got.String())
+		}
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	const src = `package main
+func loop() {
+	n := 1
+	if !n {
+		n = 2
+	}
+}`
+	if err := Transpile(io.Discard, strings.NewReader(src), nil); err != nil {
+		t.Errorf("expected no error without StrictMode, got %v", err)
+	}
+	err := Transpile(io.Discard, strings.NewReader(src), &Options{StrictMode: true})
+	if want := "operator ! not defined on n of type int (line 4)"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+}
+
+func TestTypeMap(t *testing.T) {
+	const src = `package main
+type WiFiClient struct{}
+func send(c *WiFiClient, data int) {}
+`
+	var out bytes.Buffer
+	opts := &Options{TypeMap: map[string]string{"WiFiClient": "WiFiClient_t"}}
+	if err := Transpile(&out, strings.NewReader(src), opts); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	const want = "void send(WiFiClient_t* c, int data) {\n}\n"
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestSkipAnnotation(t *testing.T) {
+	const src = `package main
+
+import "fmt"
+
+// hostOnly is only used by tests on the host.
+//mugo:skip
+func hostOnly() {
+	fmt.Println("not on the MCU")
+}
+
+func setup() {
+	pinMode(13, OUTPUT)
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	const want = "// MUGO SKIP: hostOnly\nvoid setup() {\n  pinMode(13, OUTPUT);\n}\n"
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestMultiReturnCpp03(t *testing.T) {
+	g, err := os.Open(filepath.Join(testDir, "multi-return", "multi-return.go"))
+	if err != nil {
+		t.Fatalf("failed to open multi-return.go: %v", err)
+	}
+	defer g.Close()
+	var out bytes.Buffer
+	if err := Transpile(&out, g, &Options{CppStandard: "c++03"}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	for _, want := range []string{
+		"readSensor_result __mugo_r0 = {0, false};\n  return __mugo_r0;",
+		"readSensor_result __mugo_r2 = readSensor(3);",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "auto") {
+		t.Errorf("unexpected auto in C++03 output:\n%s", out.String())
+	}
+}
+
+func TestPreserveComments(t *testing.T) {
+	const src = `// Blink turns an LED on and off.
+package main
+
+// ledPin is the pin the LED is connected to.
+const ledPin = 13
+
+// setup configures the LED pin.
+//mugo:skip
+func setup() {
+}
+
+func loop() {
+	// Turn the LED on.
+	digitalWrite(ledPin, HIGH) // and wait
+	delay(1000)
+	/* Then off. */
+	digitalWrite(ledPin, LOW)
+	// Done.
+}
+`
+	const want = `// Blink turns an LED on and off.
+// ledPin is the pin the LED is connected to.
+const int ledPin = 13;
+// setup configures the LED pin.
+// MUGO SKIP: setup
+void loop() {
+  // Turn the LED on.
+  digitalWrite(ledPin, HIGH);
+  // and wait
+  delay(1000);
+  /* Then off. */
+  digitalWrite(ledPin, LOW);
+  // Done.
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{PreserveComments: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+	out.Reset()
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if strings.Contains(out.String(), "//") && !strings.Contains(out.String(), "MUGO SKIP") {
+		t.Errorf("unexpected comments without PreserveComments:\n%s", out.String())
+	}
+}
+
+func TestIndentStyle(t *testing.T) {
+	const src = `package main
+
+func loop() {
+	delay(1000)
+}
+`
+	for _, test := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, "void loop() {\n  delay(1000);\n}\n"},
+		{Options{IndentWidth: 4}, "void loop() {\n    delay(1000);\n}\n"},
+		{Options{IndentStyle: "tabs"}, "void loop() {\n\tdelay(1000);\n}\n"},
+		{Options{IndentStyle: "tabs", IndentWidth: 2}, "void loop() {\n\t\tdelay(1000);\n}\n"},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), &test.opts); err != nil {
+			t.Errorf("%+v: failed to transpile: %v", test.opts, err)
+			continue
+		}
+		if out.String() != test.want {
+			t.Errorf("%+v: expected %q, got %q", test.opts, test.want, out.String())
+		}
+	}
+	for _, opts := range []Options{{IndentStyle: "mixed"}, {IndentWidth: -1}} {
+		if err := Transpile(io.Discard, strings.NewReader(src), &opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+
+	// The values of a multi-value assignment are assigned on their own
+	// lines.
+	const multi = `package main
+
+func pair() (int, int) {
+	return 1, 2
+}
+
+func loop() {
+	a, b := pair()
+	delay(a + b)
+}
+`
+	const want = "struct pair_result {\n\tint r0;\n\tint r1;\n};\npair_result pair() {\n\treturn {1, 2};\n}\n" +
+		"void loop() {\n\tauto __mugo_r0 = pair();\n\tint a = __mugo_r0.r0;\n\tint b = __mugo_r0.r1;\n\tdelay(a+b);\n}\n"
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(multi), &Options{IndentStyle: "tabs"}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
+
+func TestConstIota(t *testing.T) {
+	const src = `package main
+
+const (
+	Off = iota
+	On
+	Blinking
+)
+
+const (
+	Bit0 = 1 << iota
+	Bit1
+)
+
+const Single = iota
+`
+	const want = `const int Off = 0;
+const int On = 1;
+const int Blinking = 2;
+const int Bit0 = 1<<0;
+const int Bit1 = 1<<1;
+const int Single = 0;
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	for _, test := range []struct {
+		path, ext, want string
+	}{
+		{"blink.go", "", "blink.cc"},
+		{"sketches/blink/blink.go", "cc", "sketches/blink/blink.cc"},
+		{"blink.go", "ino", "blink.ino"},
+		{"blink.go", ".cpp", "blink.cpp"},
+		{"blink", "", "blink.cc"},
+	} {
+		if got := OutputPath(test.path, test.ext); got != test.want {
+			t.Errorf("OutputPath(%q, %q) = %q, expected %q", test.path, test.ext, got, test.want)
+		}
+	}
+}
+
+func TestTranspileFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "sketch.go")
+	if err := os.WriteFile(in, []byte("package main\n\nconst ledPin = 13\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := TranspileFile(in, "", nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	bs, err := os.ReadFile(filepath.Join(dir, "sketch.cc"))
+	if err != nil {
+		t.Fatalf("failed to read the output: %v", err)
+	}
+	if want := "const int ledPin = 13;\n"; string(bs) != want {
+		t.Errorf("expected %q, got %q", want, bs)
+	}
+}
+
+func TestRecursiveStruct(t *testing.T) {
+	const src = `package main
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+type Tree struct {
+	Root *Branch
+	Size int
+}
+
+type Branch struct {
+	Owner *Tree
+	Left *Branch
+}
+`
+	const want = `struct Node;
+struct Node {
+  int Value;
+  Node* Next;
+};
+struct Branch;
+struct Tree {
+  Branch* Root;
+  int Size;
+};
+struct Branch {
+  Tree* Owner;
+  Branch* Left;
+};
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestEmbeddedInterface(t *testing.T) {
+	const src = `package main
+
+type Reader interface {
+	Read() int
+}
+
+type ReadWriter interface {
+	Reader
+	Write(v int)
+}
+
+type register struct {
+	v int
+}
+
+func (r *register) Read() int {
+	return r.v
+}
+
+func (r *register) Write(v int) {
+	r.v = v
+}
+
+func loop() {
+	var rw ReadWriter = &register{0}
+	rw.Write(rw.Read() + 1)
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	for _, call := range []string{"register_Read(&rw)", "register_Write(&rw,"} {
+		if !strings.Contains(out.String(), call) {
+			t.Errorf("expected a call to %s in:\n%s", call, out.String())
+		}
+	}
+
+	missing := strings.Replace(src, "func (r *register) Read() int {\n\treturn r.v\n}\n", "", 1)
+	err := Transpile(io.Discard, strings.NewReader(missing), nil)
+	if want := "failed to resolve interface dispatch: *register assigned to interface variable rw does not implement ReadWriter (missing method Read)"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestSerialBegin(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		opts *Options
+		want string
+	}{
+		{
+			"package main\n\nfunc setup() {\n\tpinMode(13, OUTPUT)\n}\n",
+			nil,
+			"void setup() {\n  pinMode(13, OUTPUT);\n}\n",
+		},
+		{
+			"package main\n\nimport \"fmt\"\n\nfunc setup() {\n\tpinMode(13, OUTPUT)\n}\n\nfunc loop() {\n\tfmt.Println(1)\n}\n",
+			nil,
+			"void setup() {\n  Serial.begin(9600);\n  pinMode(13, OUTPUT);\n}\n",
+		},
+		{
+			"package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc setup() {\n}\n\nfunc loop() {\n\tfmt.Fprintf(os.Stdout, \"%d\", 1)\n}\n",
+			&Options{SerialBaud: 115200},
+			"void setup() {\n  Serial.begin(115200);\n}\n",
+		},
+		{
+			"package main\n\nimport \"log\"\n\nfunc setup() {\n}\n\nfunc loop() {\n\tlog.Print(1)\n}\n",
+			&Options{SerialBaud: -1},
+			"void setup() {\n}\n",
+		},
+		{
+			"package main\n\nimport \"log\"\n\nfunc loop() {\n\tlog.Printf(\"%d\", 1)\n}\n",
+			nil,
+			"void setup() {\n  Serial.begin(9600);\n}\n",
+		},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(test.src), test.opts); err != nil {
+			t.Errorf("failed to transpile %q: %v", test.src, err)
 			continue
 		}
-		ino := string(bs)
+		if !strings.Contains(out.String(), test.want) {
+			t.Errorf("expected %q in:\n%s", test.want, out.String())
+		}
+		if test.want == "void setup() {\n}\n" || !strings.Contains(test.src, "fmt.") && !strings.Contains(test.src, "log.") {
+			if strings.Contains(out.String(), "Serial.begin") {
+				t.Errorf("unexpected Serial.begin in:\n%s", out.String())
+			}
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	const src = `package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+var name = "led"
+
+func loop() {
+	n := 3
+	fmt.Println("pin", n)
+	fmt.Print(n, n, name, n)
+	fmt.Printf("%d\n", n)
+	fmt.Fprintf(os.Stdout, "%d", n)
+	log.Print(n)
+	log.Printf("%d", n)
+	log.Println()
+	fmt.Print()
+}
+`
+	const want = `const char * name = "led";
+void loop() {
+  int n = 3;
+  Serial.print("pin"), Serial.print(" "), Serial.println(n);
+  Serial.print(n), Serial.print(" "), Serial.print(n), Serial.print(name), Serial.print(n);
+  Serial.printf("%d\n", n);
+  Serial.printf("%d", n);
+  Serial.println(n);
+  Serial.printf("%d", n), Serial.println();
+  Serial.println();
+  (void)0;
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{SerialBaud: -1}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+	const sprintf = "package main\n\nimport \"fmt\"\n\nfunc loop() {\n\tSerial.println(fmt.Sprintf(\"%d\", 1))\n}\n"
+	if err := Transpile(io.Discard, strings.NewReader(sprintf), nil); err == nil || !strings.Contains(err.Error(), "unsupported call to fmt.Sprintf") {
+		t.Errorf("expected an error for fmt.Sprintf, got %v", err)
+	}
+}
+
+func TestArduinoImport(t *testing.T) {
+	const src = `package main
+
+import "github.com/googlesamples/mugo/sketches/arduino"
+
+func loop() {
+	arduino.DigitalWrite(arduino.LED_BUILTIN, arduino.HIGH)
+	arduino.Delay(1000)
+}
+`
+	const want = `#include <Arduino.h>
+void loop() {
+  digitalWrite(LED_BUILTIN, HIGH);
+  delay(1000);
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+
+	err := Transpile(io.Discard, strings.NewReader("package main\n\nimport \"net/http\"\n"), nil)
+	if want := `unsupported import "net/http" (line 3)`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+}
+
+func TestBinaryOperators(t *testing.T) {
+	for _, test := range []struct {
+		expr, want string
+	}{
+		{"a + b", "a+b"},
+		{"a - b", "a-b"},
+		{"a * b", "a*b"},
+		{"a / b", "a/b"},
+		{"a % b", "a%b"},
+		{"a == b", "a==b"},
+		{"a != b", "a!=b"},
+		{"a < b", "a<b"},
+		{"a <= b", "a<=b"},
+		{"a > b", "a>b"},
+		{"a >= b", "a>=b"},
+		{"c && d", "c&&d"},
+		{"c || d", "c||d"},
+		{"a & b", "a&b"},
+		{"a | b", "a|b"},
+		{"a ^ b", "a^b"},
+		{"a << b", "a<<b"},
+		{"a >> b", "a>>b"},
+		{"u >> b", "u>>b"},
+		{"a &^ b", "a&~b"},
+		{"a &^ (b | 1)", "a&~(b|1)"},
+		{"a - (b - 1)", "a-(b-1)"},
+		{"a - b - 1", "a-b-1"},
+		{"a - b + 1", "a-b+1"},
+		{"a&b == 0", "(a&b)==0"},
+		{"a&^b != 0", "(a&~b)!=0"},
+		{"a|b^a&b", "(a|b)^a&b"},
+		{"1<<a + 1", "(1<<a)+1"},
+		{"u>>1 | u<<1", "u>>1|u<<1"},
+		{"a*b + a/b", "a*b+a/b"},
+		{"c && a < b || d", "c&&a<b||d"},
+	} {
+		src := "package main\n\nvar a, b int\nvar u uint\nvar c, d bool\n\nfunc loop() {\n\tx := " + test.expr + "\n}\n"
+		f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", test.expr, err)
+		}
+		e := f.Decls[3].(*ast.FuncDecl).Body.List[0].(*ast.AssignStmt).Rhs[0]
 		var out bytes.Buffer
-		if err := Transpile(&out, g, nil); err != nil {
-			t.Errorf("failed to transpile sketch %q: %v", s, err)
+		o := &output{Writer: &out, opts: &Options{}, target: &TargetConfig{}, unsupported: &[]TranspileError{}, symbols: newSymbolTable()}
+		if err := handleExpr(o, e); err != nil {
+			t.Errorf("%s: %v", test.expr, err)
 			continue
 		}
-		if nospace(ino) != nospace(out.String()) {
-			t.Errorf("expected:\n%s-- got:\n%s", ino, out.String())
+		if out.String() != test.want {
+			t.Errorf("%s: expected %s, got %s", test.expr, test.want, out.String())
 		}
 	}
+}
+
+func TestGlobalCallInitializer(t *testing.T) {
+	const src = `package main
 
+var threshold = calibrate(3)
+
+var pin int = pick()
+
+var last = sensor.Read()
+
+type probe struct {
+	v int
 }
 
-func nospace(s string) string {
-	s = strings.Replace(s, " ", "", -1)
-	s = strings.Replace(s, "\r", "", -1)
-	s = strings.Replace(s, "\n", "", -1)
-	return s
+func (p probe) Read() int {
+	return p.v
+}
+
+var sensor = probe{1}
+
+func calibrate(n int) int {
+	return n * 2
+}
+`
+	const want = `int threshold = calibrate(3);
+int pin = pick();
+`
+	var out bytes.Buffer
+	err := Transpile(&out, strings.NewReader(src), nil)
+	if !strings.HasPrefix(out.String(), want) {
+		t.Errorf("expected output starting with:\n%s-- got:\n%s", want, out.String())
+	}
+	if want := "cannot determine the type of last from the result of sensor.Read; declare it explicitly (line 7)"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+
+	out.Reset()
+	src2 := strings.Replace(src, "var last = sensor.Read()\n", "", 1)
+	if err := Transpile(&out, strings.NewReader(src2), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if !strings.Contains(out.String(), "probe sensor = {1};") {
+		t.Errorf("expected the sensor global in:\n%s", out.String())
+	}
+}
+
+func TestBlankImport(t *testing.T) {
+	const src = `package main
+
+import (
+	_ "arduino"
+	_ "image/png"
+)
+`
+	const want = `#include <Arduino.h>
+// NOTE: blank import "image/png" has no effect in mugo
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestStringLiteralType(t *testing.T) {
+	const src = "package main\n\nvar greeting = \"hello\"\n\nfunc loop() {\n\tx := \"hello\"\n\ty := `raw`\n\tdelay(len(x) + len(y))\n}\n"
+	const want = `const char * greeting = "hello";
+void loop() {
+  const char * x = "hello";
+  const char * y = "raw";
+  delay(strlen(x)+strlen(y));
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestCommentPositions(t *testing.T) {
+	const src = `package main
+
+func setup() {
+	pinMode(13, OUTPUT)
+} // setup done
+
+// Between setup and loop.
+
+func loop() {
+	if ready() {
+		// Inside the if.
+		delay(1)
+	}
+	// After the if.
+}
+
+// At the end.
+`
+	const want = `void setup() {
+  pinMode(13, OUTPUT);
+}
+// setup done
+// Between setup and loop.
+void loop() {
+  if (ready()) {
+  // Inside the if.
+  delay(1);
+}
+  // After the if.
+}
+// At the end.
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{PreserveComments: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestStringConcat(t *testing.T) {
+	const src = `package main
+
+func greet(name string) string {
+	return "hello, " + name + "!"
+}
+
+func loop() {
+	s := "hello" + " world"
+	n := 1 + 2
+	delay(len(s) + n)
+}
+`
+	const want = `#include <stdlib.h>
+#include <string.h>
+const char * __mugo_strcat(const char * a, const char * b) {
+  char * s = (char *)malloc(strlen(a) + strlen(b) + 1);
+  strcpy(s, a);
+  strcat(s, b);
+  return s;
+}
+const char * greet(const char * name) {
+  return __mugo_strcat(__mugo_strcat("hello, ", name), "!");
+}
+void loop() {
+  const char * s = __mugo_strcat("hello", " world");
+  int n = 1+2;
+  delay(strlen(s)+n);
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestFuncLit(t *testing.T) {
+	const src = `package main
+
+var count = 0
+
+func setup() {
+	attachInterrupt(0, func() {
+		count++
+	}, RISING)
+}
+`
+	const want = `int count = 0;
+void __mugo_lambda_0() {
+  count++;
+}
+void setup() {
+  attachInterrupt(0, __mugo_lambda_0, RISING);
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+
+	const closure = `package main
+
+func setup() {
+	count := 0
+	attachInterrupt(0, func() {
+		n := 1
+		count += n
+	}, RISING)
+}
+`
+	err := Transpile(io.Discard, strings.NewReader(closure), nil)
+	if want := "function literal captures the local variable count (line 7)"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+}
+
+func TestArena(t *testing.T) {
+	const src = `package main
+
+//mugo:arena 256
+
+type Sensor struct {
+	Pin int
+}
+
+func setup() {
+	s := new(Sensor)
+	s.Pin = 3
+}
+`
+	const want = `#include <stdint.h>
+#include <stddef.h>
+alignas(max_align_t) uint8_t __mugo_arena[256];
+unsigned int __mugo_arena_used = 0;
+void * __mugo_alloc(unsigned int n) {
+  n = (n + alignof(max_align_t) - 1) / alignof(max_align_t) * alignof(max_align_t);
+  if (n > sizeof(__mugo_arena) - __mugo_arena_used) {
+    return 0;
+  }
+  void * p = __mugo_arena + __mugo_arena_used;
+  __mugo_arena_used += n;
+  return p;
+}
+struct Sensor {
+  int16_t Pin;
+};
+void setup() {
+  Sensor* s = (Sensor*)__mugo_alloc(sizeof(Sensor));
+  s->Pin=3;
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{Target: "avr", MaxRAM: 2048}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+	if msg, err := checkSyntax(t, "c++11", out.String()); err != nil {
+		t.Errorf("failed to compile the output: %v\n%s", err, msg)
+	}
+	// Without alignas, the arena is aligned with a GCC attribute.
+	out.Reset()
+	if err := Transpile(&out, strings.NewReader(src), &Options{Target: "avr", MaxRAM: 2048, CppStandard: "c99"}); err != nil {
+		t.Fatalf("failed to transpile to C: %v", err)
+	}
+	if !strings.Contains(out.String(), "uint8_t __mugo_arena[256] __attribute__((aligned(__BIGGEST_ALIGNMENT__)));") {
+		t.Errorf("expected an aligned arena, got:\n%s", out.String())
+	}
+	if msg, err := checkSyntax(t, "c99", out.String()); err != nil {
+		t.Errorf("failed to compile the output to C: %v\n%s", err, msg)
+	}
+
+	for _, test := range []struct {
+		src  string
+		opts *Options
+		err  string
+	}{
+		{strings.Replace(src, "256", "4096", 1), &Options{Target: "avr"}, "arena of 4096 bytes exceeds the 2048 bytes of RAM"},
+		{strings.Replace(src, "256", "4096", 1), &Options{Target: "avr", MaxRAM: 1024}, "arena of 4096 bytes exceeds the 1024 bytes of RAM"},
+		{strings.Replace(src, "256", "lots", 1), nil, `invalid arena size "lots" (line 3)`},
+		{src, &Options{Target: "pdp11"}, `unknown target "pdp11"`},
+	} {
+		err := Transpile(io.Discard, strings.NewReader(test.src), test.opts)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error containing %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestInterfaceField(t *testing.T) {
+	const src = `package main
+
+type Reader interface {
+	Read() int
+}
+
+type Filter struct {
+	Reader
+	source Reader
+	gain   int
+}
+
+func setup() {
+	f := Filter{gain: 3}
+	delay(f.gain)
+}
+`
+	// Interface fields are null in the zero value of the struct, which
+	// remains an aggregate.
+	const want = `struct Filter {
+  void* Reader;
+  void* source;
+  int gain;
+};
+void setup() {
+  Filter f = {.gain = 3};
+  delay(f.gain);
+}
+`
+	for _, std := range []string{"", "c++03"} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), &Options{CppStandard: std}); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if out.String() != want {
+			t.Errorf("%q: expected:\n%s-- got:\n%s", std, want, out.String())
+		}
+		if msg, err := checkSyntax(t, "c++11", out.String()); err != nil {
+			t.Errorf("failed to compile the output: %v\n%s", err, msg)
+		}
+	}
+}
+
+func TestCopyString(t *testing.T) {
+	const src = `package main
+
+var greeting = "hello"
+
+func checksum() int {
+	var buf [8]byte
+	copy(buf[:], greeting)
+	sum := 0
+	for i := 0; i < len(buf); i++ {
+		sum += int(buf[i])
+	}
+	b := []byte(greeting)
+	sum += int(b[0])
+	return sum
+}
+`
+	const want = `const char * greeting = "hello";
+int checksum() {
+  uint8_t buf[8] = {};
+  memcpy(buf, greeting, ((sizeof(buf)/sizeof(buf[0])) < strlen(greeting) ? (sizeof(buf)/sizeof(buf[0])) : strlen(greeting)) * sizeof(buf[0]));
+  int sum = 0;
+  for (int i = 0; i<(sizeof(buf)/sizeof(buf[0])); i++) {
+  sum+=int(buf[i]);
+}
+  uint8_t* b = (uint8_t*)greeting;
+  sum+=int(b[0]);
+  return sum;
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestBlankAssign(t *testing.T) {
+	const src = `package main
+
+func setup() {
+	var arr [4]int
+	_ = len(arr)
+	_ = arr[0] + 1
+}
+`
+	const want = `void setup() {
+  int arr[4] = {};
+  (void)(sizeof(arr)/sizeof(arr[0]));
+  (void)(arr[0]+1);
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestEmptyStmt(t *testing.T) {
+	const src = `package main
+
+func loop() {
+	if ready() {
+		;
+	}
+	delay(1);;
+}
+`
+	const want = `void loop() {
+  if (ready()) {
+  ;
+}
+  delay(1);
+  ;
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestTargetESP32(t *testing.T) {
+	const src = `package main
+
+//mugo:arena 65536
+
+func check(err error) bool {
+	return err != nil
+}
+`
+	const want = `#include "Arduino.h"
+#include <stdint.h>
+#include <stddef.h>
+alignas(max_align_t) uint8_t __mugo_arena[65536];
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{Target: "esp32"}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), want) {
+		t.Errorf("expected output starting with:\n%s-- got:\n%s", want, out.String())
+	}
+	if !strings.Contains(out.String(), "bool check(esp_err_t err) {\n  return err!=0;\n}\n") {
+		t.Errorf("expected error to map to esp_err_t in:\n%s", out.String())
+	}
+
+	out.Reset()
+	err := Transpile(&out, strings.NewReader(src), &Options{Target: "avr"})
+	if want := "arena of 65536 bytes exceeds the 2048 bytes of RAM"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestTypeAlias(t *testing.T) {
+	const src = `package main
+
+type Byte = byte
+
+type MySensor struct {
+	Pin  int
+	Next *Sensor
+}
+
+type Sensor = MySensor
+
+type Reader interface {
+	Read() int
+}
+
+type Source = Reader
+
+var b Byte = 1
+
+var s Sensor
+`
+	const want = `typedef uint8_t Byte;
+struct MySensor;
+struct MySensor {
+  int Pin;
+  MySensor* Next;
+};
+typedef MySensor Sensor;
+uint8_t b = 1;
+MySensor s = {};
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestNestedStruct(t *testing.T) {
+	const src = `package main
+
+type Reading struct {
+	Source  Sensor
+	History [2]Sample
+	Value   int
+}
+
+type Sample struct {
+	At    uint32
+	Value int
+}
+
+type Sensor struct {
+	Pin  int
+	Last Sample
+}
+`
+	const want = `struct Sample {
+  uint32_t At;
+  int Value;
+};
+struct Sensor {
+  int Pin;
+  Sample Last;
+};
+struct Reading {
+  Sensor Source;
+  Sample History[2];
+  int Value;
+};
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestCInclude(t *testing.T) {
+	const src = `// Sleepy blinks and sleeps.
+package main
+
+//mugo:c_include <avr/sleep.h>
+//mugo:c_include <avr/power.h>
+
+// sleep puts the MCU to sleep.
+//mugo:c_include <avr/sleep.h>
+//mugo:c_include "board.h"
+func sleep() {
+	//mugo:c_include <ignored.h>
+	sleep_mode()
+}
+`
+	const want = `#include <avr/sleep.h>
+#include <avr/power.h>
+#include "board.h"
+// Sleepy blinks and sleeps.
+// sleep puts the MCU to sleep.
+void sleep() {
+  sleep_mode();
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{PreserveComments: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestLocalType(t *testing.T) {
+	const src = `package main
+
+func loop() {
+	type reading struct {
+		Pin   int
+		Value int
+	}
+	r := reading{Pin: 3}
+	r.Value = analogRead(r.Pin)
+}
+
+func setup() {
+	type reading struct {
+		Pin int
+	}
+	var r reading
+	r.Pin = 1
+}
+`
+	const want = `void loop() {
+  struct reading {
+  int Pin;
+  int Value;
+};
+  reading r = {.Pin = 3};
+  r.Value=analogRead(r.Pin);
+}
+void setup() {
+  struct reading {
+  int Pin;
+};
+  reading r = {};
+  r.Pin=1;
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+
+	// A local type only shadows the package level type of the same name
+	// in its block.
+	const shadowing = `package main
+
+type Reader interface {
+	Read() int
+}
+
+type pin struct {
+	n int
+}
+
+func (p pin) Read() int {
+	return analogRead(p.n)
+}
+
+func a() int {
+	type Reader struct {
+		n int
+	}
+	var r Reader
+	return r.n
+}
+
+func b() int {
+	var r Reader = pin{3}
+	return r.Read()
+}
+`
+	out.Reset()
+	if err := Transpile(&out, strings.NewReader(shadowing), &Options{InterfaceDispatch: "vtable"}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if want := "  Reader r = {&__mugo_r0, &Reader_pin_vtable};\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("expected the interface value %q in:\n%s", want, out.String())
+	}
+}
+
+func TestInit(t *testing.T) {
+	const src = `package main
+
+var count int
+
+func init() {
+	count = 3
+}
+
+func setup() {
+	pinMode(13, OUTPUT)
+}
+
+func init() {
+	n := count * 2
+	digitalWrite(n, HIGH)
+}
+`
+	for _, tc := range []struct {
+		opts *Options
+		want string
+	}{{&Options{}, `int count = 0;
+void __mugo_init0() {
+  count=3;
+}
+void __mugo_init1();
+void setup() {
+  __mugo_init0();
+  __mugo_init1();
+  pinMode(13, OUTPUT);
+}
+void __mugo_init1() {
+  int n = count*2;
+  digitalWrite(n, HIGH);
+}
+`}, {&Options{InlineInit: true}, `int count = 0;
+void setup() {
+  {
+  count=3;
+}
+  {
+  int n = count*2;
+  digitalWrite(n, HIGH);
+}
+  pinMode(13, OUTPUT);
+}
+`}} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), tc.opts); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if out.String() != tc.want {
+			t.Errorf("expected with %+v:\n%s-- got:\n%s", *tc.opts, tc.want, out.String())
+		}
+	}
+}
+
+func TestInitWithoutSetup(t *testing.T) {
+	const src = `package main
+
+func init() {
+	pinMode(13, OUTPUT)
+}
+`
+	const want = `void __mugo_init() {
+  pinMode(13, OUTPUT);
+}
+void setup() {
+  __mugo_init();
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+	err := Transpile(&out, strings.NewReader("package main\n\nfunc init() {\n\treturn\n}\n"), &Options{InlineInit: true})
+	if err == nil || !strings.Contains(err.Error(), "cannot be inlined") {
+		t.Errorf("expected an error inlining an init which returns, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	const src = `package main
+
+type Sensor struct {
+	Pin int
+}
+
+func setup() {
+	n := new(int)
+	b := new([]byte)
+	s := new(Sensor)
+	s.Pin = *n
+	_ = b
+}
+`
+	for _, tc := range []struct {
+		opts *Options
+		want string
+	}{{&Options{}, `struct Sensor {
+  int Pin;
+};
+static int __mugo_new0;
+static uint8_t* __mugo_new1;
+static Sensor __mugo_new2;
+void setup() {
+  int* n = &__mugo_new0;
+  uint8_t** b = &__mugo_new1;
+  Sensor* s = &__mugo_new2;
+  s->Pin=*n;
+  (void)b;
+}
+`}, {&Options{UseHeap: true}, `struct Sensor {
+  int Pin;
+};
+void setup() {
+  int* n = new (int)();
+  uint8_t** b = new (uint8_t*)();
+  Sensor* s = new (Sensor)();
+  s->Pin=*n;
+  (void)b;
+}
+`}} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), tc.opts); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if out.String() != tc.want {
+			t.Errorf("expected with %+v:\n%s-- got:\n%s", *tc.opts, tc.want, out.String())
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	const src = `package main
+
+var buf [16]byte
+var header = [2]byte{0xAA, 0x55}
+
+func send() {
+	var scratch [4]byte
+	out := buf[:0]
+	out = append(out, header...)
+	out = append(out, 1, 2)
+	tail := scratch[:2]
+	out = append(out, 3, tail...)
+	Serial.write(out, len(out))
+}
+`
+	const want = `uint8_t buf[16] = {};
+uint8_t header[2] = {0xAA, 0x55};
+void send() {
+  uint8_t scratch[4] = {};
+  uint8_t* out = buf; int out_len = 0;
+  for (int __mugo_i = 0; __mugo_i<(sizeof(header)/sizeof(header[0])); __mugo_i++) {
+  out[out_len++]=header[__mugo_i];
+}
+  out[out_len++]=1;
+  out[out_len++]=2;
+  uint8_t* tail = scratch; int tail_len = 2;
+  out[out_len++]=3;
+  for (int __mugo_i = 0; __mugo_i<tail_len; __mugo_i++) {
+  out[out_len++]=tail[__mugo_i];
+}
+  Serial.write(out, out_len);
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+	// The lengths and loop counters are as wide as int.
+	out.Reset()
+	if err := Transpile(&out, strings.NewReader(src), &Options{IntWidth: 16}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if want16 := strings.ReplaceAll(want, "int ", "int16_t "); out.String() != want16 {
+		t.Errorf("expected:\n%s-- got:\n%s", want16, out.String())
+	}
+	for _, src := range []string{
+		"package main\n\nfunc send(s []byte) {\n\ts = append(s, 1)\n}\n",
+		// The length of a slice of an array is not that of another
+		// variable of the same name.
+		"package main\n\nvar buf [4]byte\n\nfunc reset() {\n\ts := buf[:0]\n\ts = append(s, 0)\n}\n\nfunc send(s []byte) {\n\ts = append(s, 1)\n}\n",
+	} {
+		err := Transpile(io.Discard, strings.NewReader(src), nil)
+		if err == nil || !strings.Contains(err.Error(), "append is only supported to a slice of an array") {
+			t.Errorf("expected an error appending to a slice of unknown length, got %v", err)
+		}
+	}
+}
+
+func TestVtableDispatch(t *testing.T) {
+	const src = `package main
+
+type Reader interface {
+	Read() int
+}
+
+type Writer interface {
+	Write(b byte, n int)
+}
+
+type ReadWriter interface {
+	Reader
+	Writer
+}
+
+type counter struct {
+	n int
+}
+
+func (c *counter) Read() int {
+	c.n = c.n + 1
+	return c.n
+}
+
+func (c *counter) Write(b byte, n int) {
+	c.n = n
+}
+
+type constant struct {
+	v int
+}
+
+func (c constant) Read() int {
+	return c.v
+}
+
+var zero = constant{0}
+var r Reader = &zero
+var k Reader = constant{3}
+
+func sum(a, b Reader) int {
+	return a.Read() + b.Read()
+}
+
+func loop() {
+	var rw ReadWriter = &counter{0}
+	rw.Write(1, 2)
+	c := constant{7}
+	r = &c
+	v := sum(r, &c)
+	delay(v)
+}
+`
+	const want = `struct Reader_vtable {
+  int (*Read)(void*);
+};
+struct Reader {
+  void* self;
+  const Reader_vtable* vtable;
+};
+struct Writer_vtable {
+  void (*Write)(void*, uint8_t, int);
+};
+struct Writer {
+  void* self;
+  const Writer_vtable* vtable;
+};
+struct ReadWriter_vtable {
+  int (*Read)(void*);
+  void (*Write)(void*, uint8_t, int);
+};
+struct ReadWriter {
+  void* self;
+  const ReadWriter_vtable* vtable;
+};
+struct counter {
+  int n;
+};
+int counter_Read(counter* c) {
+  c->n=c->n+1;
+  return c->n;
+}
+void counter_Write(counter* c, uint8_t b, int n) {
+  c->n=n;
+}
+struct constant {
+  int v;
+};
+int constant_Read(constant c) {
+  return c.v;
+}
+constant zero = {0};
+extern const Reader_vtable Reader_constant_vtable;
+Reader r = {&zero, &Reader_constant_vtable};
+constant __mugo_r0 = {3};
+Reader k = {&__mugo_r0, &Reader_constant_vtable};
+int sum(Reader a, Reader b) {
+  return a.vtable->Read(a.self)+b.vtable->Read(b.self);
+}
+extern const ReadWriter_vtable ReadWriter_counter_vtable;
+void loop() {
+  counter __mugo_r1 = {0};
+  ReadWriter rw = {&__mugo_r1, &ReadWriter_counter_vtable};
+  rw.vtable->Write(rw.self, 1, 2);
+  constant c = {7};
+  r=Reader{&c, &Reader_constant_vtable};
+  int v = sum(r, Reader{&c, &Reader_constant_vtable});
+  delay(v);
+}
+int __mugo_Reader_constant_Read(void* self) {
+  return constant_Read(*(constant*)self);
+}
+const Reader_vtable Reader_constant_vtable = {__mugo_Reader_constant_Read};
+int __mugo_ReadWriter_counter_Read(void* self) {
+  return counter_Read((counter*)self);
+}
+void __mugo_ReadWriter_counter_Write(void* self, uint8_t a0, int a1) {
+  counter_Write((counter*)self, a0, a1);
+}
+const ReadWriter_vtable ReadWriter_counter_vtable = {__mugo_ReadWriter_counter_Read, __mugo_ReadWriter_counter_Write};
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{InterfaceDispatch: "vtable"}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+
+	for _, test := range []struct {
+		src  string
+		opts *Options
+		err  string
+	}{
+		{src, &Options{InterfaceDispatch: "vtable", CppStandard: "c++03"}, "converting a value to Reader outside of a declaration requires C++11"},
+		{strings.Replace(src, "sum(r, &c)", "sum(r, rw)", 1), nil, "conversion of rw from interface ReadWriter to Reader is not supported"},
+		{strings.Replace(src, "\tr = &c", "\tr = c", 1), nil, "c must be converted to Reader by address"},
+		{strings.Replace(src, "\tr = &c", "\tr = &rw", 1), nil, "cannot determine the concrete type of &rw converted to Reader"},
+		{strings.Replace(src, "func (c *counter) Read() int", "func (c *counter) Count() int", 1), nil, "counter does not implement ReadWriter (missing method Read)"},
+	} {
+		if test.opts == nil {
+			test.opts = &Options{InterfaceDispatch: "vtable"}
+		}
+		err := Transpile(io.Discard, strings.NewReader(test.src), test.opts)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error containing %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestArduinoMode(t *testing.T) {
+	const blink = `package main
+
+func main() {
+	led := 13
+	var period int = 500
+	pinMode(led, OUTPUT)
+	for {
+		digitalWrite(led, HIGH)
+		delay(period)
+		digitalWrite(led, LOW)
+		delay(period)
+	}
+}
+`
+	for _, tc := range []struct {
+		src, want string
+	}{{blink, `int led = 0;
+int period = 0;
+void setup() {
+  led=13;
+  period=500;
+  pinMode(led, OUTPUT);
+}
+void loop() {
+  digitalWrite(led, HIGH);
+  delay(period);
+  digitalWrite(led, LOW);
+  delay(period);
+}
+`}, {"package main\n\nfunc main() {\n\tpinMode(13, OUTPUT)\n}\n", `void setup() {
+  pinMode(13, OUTPUT);
+}
+void loop() {
+}
+`}} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(tc.src), &Options{ArduinoMode: true}); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if out.String() != tc.want {
+			t.Errorf("expected:\n%s-- got:\n%s", tc.want, out.String())
+		}
+	}
+
+	for _, test := range []struct {
+		src, err string
+	}{
+		{blink + "\nfunc loop() {}\n", "main cannot be turned into setup and loop as loop is declared at line 15"},
+		{"package main\n\nfunc setup() {}\n\nfunc main() {}\n", "main cannot be turned into setup and loop as setup is declared at line 3"},
+		{strings.Replace(blink, "\t}\n}", "\t}\n\tdelay(1)\n}", 1), "statement after the infinite loop of main is unreachable (line 13)"},
+	} {
+		err := Transpile(io.Discard, strings.NewReader(test.src), &Options{ArduinoMode: true})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error containing %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestStandards(t *testing.T) {
+	const src = `// Package main blinks.
+package main
+
+type Reader interface {
+	Read() int
+}
+
+type node struct {
+	next   *node
+	source Reader
+	on     bool
+}
+
+// toggle flips the node.
+func toggle(n *node) bool {
+	n.on = !n.on
+	return n.on
+}
+
+func level(v byte) int {
+	var last node
+	last.on = v > 0
+	return int(v)
+}
+`
+	for _, tc := range []struct {
+		std, want string
+	}{{"c99", `/* Package main blinks. */
+#include <stdbool.h>
+typedef struct node node;
+struct node {
+  node* next;
+  void* source;
+  bool on;
+};
+/* toggle flips the node. */
+bool toggle(node* n) {
+  n->on=!n->on;
+  return n->on;
+}
+int level(uint8_t v) {
+  node last = {0};
+  last.on=v>0;
+  return (int)(v);
+}
+`}, {"c++11", `// Package main blinks.
+struct node;
+struct node {
+  node* next;
+  void* source;
+  bool on;
+};
+// toggle flips the node.
+bool toggle(node* n) {
+  n->on=!n->on;
+  return n->on;
+}
+int level(uint8_t v) {
+  node last = {};
+  last.on=v>0;
+  return int(v);
+}
+`}} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), &Options{CppStandard: tc.std, PreserveComments: true}); err != nil {
+			t.Fatalf("failed to transpile to %s: %v", tc.std, err)
+		}
+		if out.String() != tc.want {
+			t.Errorf("expected with %s:\n%s-- got:\n%s", tc.std, tc.want, out.String())
+		}
+		if msg, err := checkSyntax(t, tc.std, out.String()); err != nil {
+			t.Errorf("failed to compile the output with %s: %v\n%s", tc.std, err, msg)
+		}
+	}
+	err := Transpile(io.Discard, strings.NewReader(src), &Options{CppStandard: "c++98"})
+	if err == nil || !strings.Contains(err.Error(), `unknown standard "c++98"`) {
+		t.Errorf("expected an unknown standard error, got %v", err)
+	}
+}
+
+func TestSliceLit(t *testing.T) {
+	const src = `package main
+
+var header = []byte{0xAA, 0x55}
+
+type packet struct {
+	data []byte
+}
+
+func send(b []byte, n int) {
+}
+
+func loop() {
+	payload := []byte{0x01, 0x02, 0x03}
+	send(payload, len(payload))
+	send([]byte{0x10, 0x20}, 2)
+	p := packet{data: []byte{0xFF}}
+	send(p.data, 1)
+	send(header, len(header))
+}
+`
+	const want = `uint8_t header[] = {0xAA, 0x55};
+struct packet {
+  uint8_t* data;
+};
+void send(uint8_t* b, int n) {
+}
+void loop() {
+  uint8_t payload[] = {0x01, 0x02, 0x03};
+  send(payload, (sizeof(payload)/sizeof(payload[0])));
+  uint8_t __mugo_buf0[] = {0x10, 0x20};
+  send(__mugo_buf0, 2);
+  uint8_t __mugo_buf1[] = {0xFF};
+  packet p = {.data = __mugo_buf1};
+  send(p.data, 1);
+  send(header, (sizeof(header)/sizeof(header[0])));
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestDeadCodeElim(t *testing.T) {
+	const src = `package main
+
+func used() {
+	delay(100)
+}
+
+func unused() {
+	delay(200)
+}
+
+func main() {
+	used()
+}
+`
+	for _, elim := range []bool{false, true} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), &Options{DeadCodeElim: elim}); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if !strings.Contains(out.String(), "void used() {") {
+			t.Errorf("used missing with DeadCodeElim %v:\n%s", elim, out.String())
+		}
+		if got := strings.Contains(out.String(), "void unused() {"); got == elim {
+			t.Errorf("unused emitted: %v with DeadCodeElim %v:\n%s", got, elim, out.String())
+		}
+	}
+}
+
+func TestReachableFunctions(t *testing.T) {
+	const src = `package main
+
+type led struct{}
+
+func (l led) on()  {}
+func (l led) off() {}
+
+var handler = onTick
+
+func onTick() {}
+
+//mugo:isr
+func onPin() {}
+
+func init() { helper() }
+
+func helper() {}
+
+func unused() { led{}.off() }
+
+func main() {
+	var l led
+	l.on()
+}
+`
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ReachableFunctions(f, []string{"main"})
+	want := map[string]bool{"led_on": true, "onTick": true, "onPin": true, "init": true, "helper": true, "main": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRangeArray(t *testing.T) {
+	const src = `package main
+
+const n = 3
+
+var pins = [4]int{2, 3, 4, 5}
+var levels [n]uint8
+
+func loop() {
+	for i, p := range pins {
+		analogWrite(p, levels[i])
+	}
+	for _, l := range levels {
+		delay(l)
+	}
+	for i := range [2]int{7, 8} {
+		delay(i)
+	}
+	for _, d := range [2]int{7, 8} {
+		delay(d)
+	}
+}
+`
+	const want = `const int n = 3;
+int pins[4] = {2, 3, 4, 5};
+uint8_t levels[n] = {};
+void loop() {
+  for (int i = 0; i<4; i++) {
+  int p = pins[i];
+  analogWrite(p, levels[i]);
+}
+  for (int __mugo_i = 0; __mugo_i<(sizeof(levels)/sizeof(levels[0])); __mugo_i++) {
+  uint8_t l = levels[__mugo_i];
+  delay(l);
+}
+  for (int i = 0; i<2; i++) {
+  delay(i);
+}
+  int __mugo_buf0[] = {7, 8};
+  for (int __mugo_i = 0; __mugo_i<2; __mugo_i++) {
+  int d = __mugo_buf0[__mugo_i];
+  delay(d);
+}
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	const src = `package main
+
+func setup() {
+	pinMode(13, OUTPUT)
+}
+
+func loop() {
+	delay(1000)
+}
+`
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	if err := Transpile(&bytes.Buffer{}, strings.NewReader(src), &Options{Verbose: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	for _, want := range []string{":3:1: handling *ast.FuncDecl", ":7:1: handling *ast.FuncDecl", ":8:2: handling *ast.ExprStmt"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected %q in the logs, got:\n%s", want, logs.String())
+		}
+	}
+
+	logs.Reset()
+	if err := Transpile(&bytes.Buffer{}, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("expected no logs without Verbose, got:\n%s", logs.String())
+	}
+}
+
+func TestIntWidth(t *testing.T) {
+	const src = `package main
+
+var x int
+var n uint = 3
+
+func loop() {
+	y := len("abc")
+	delay(x + y)
+}
+`
+	for _, test := range []struct {
+		opts *Options
+		want string
+	}{
+		{nil, "int x = 0;\nunsigned int n = 3;\nvoid loop() {\n  int y = strlen(\"abc\");\n  delay(x+y);\n}\n"},
+		{&Options{Target: "avr"}, "#include <stdint.h>\nint16_t x = 0;\nuint16_t n = 3;\nvoid loop() {\n  int16_t y = strlen(\"abc\");\n  delay(x+y);\n}\n"},
+		{&Options{Target: "avr", IntWidth: 32}, "#include <stdint.h>\nint32_t x = 0;\nuint32_t n = 3;\nvoid loop() {\n  int32_t y = strlen(\"abc\");\n  delay(x+y);\n}\n"},
+		{&Options{Target: "esp32"}, "#include \"Arduino.h\"\n#include <stdint.h>\nint32_t x = 0;\nuint32_t n = 3;\nvoid loop() {\n  int32_t y = strlen(\"abc\");\n  delay(x+y);\n}\n"},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), test.opts); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if out.String() != test.want {
+			t.Errorf("expected:\n%s-- got:\n%s", test.want, out.String())
+		}
+	}
+	if err := Transpile(&bytes.Buffer{}, strings.NewReader(src), &Options{IntWidth: 8}); err == nil || !strings.Contains(err.Error(), "unsupported int width 8") {
+		t.Errorf("expected an error for an 8 bit int, got %v", err)
+	}
+}
+
+func TestExternFunc(t *testing.T) {
+	const src = `package main
+
+type reading struct {
+	a, b, c, d int32
+}
+
+func ExternalFunc(x int) int
+
+func record(r reading)
+
+func loop() {
+	delay(ExternalFunc(3))
+}
+`
+	const want = `struct reading {
+  int32_t a;
+  int32_t b;
+  int32_t c;
+  int32_t d;
+};
+extern int ExternalFunc(int x);
+extern void record(reading r);
+void loop() {
+  delay(ExternalFunc(3));
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestCCode(t *testing.T) {
+	const src = `package main
+
+var counter int
+
+//mugo:c_code
+const _ = ` + "`" + `#define BARRIER() asm volatile("" ::: "memory")
+static const char banner[] = "hi\n";` + "`" + `
+
+func loop() {
+	counter++
+	//mugo:c_code
+	var _ = ` + "`BARRIER();`" + `
+	delay(counter)
+}
+`
+	const want = `int counter = 0;
+#define BARRIER() asm volatile("" ::: "memory")
+static const char banner[] = "hi\n";
+void loop() {
+  counter++;
+  BARRIER();
+  delay(counter);
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+
+	const bad = "package main\n\n//mugo:c_code\nvar x = `nop();`\n"
+	if err := Transpile(&bytes.Buffer{}, strings.NewReader(bad), nil); err == nil || !strings.Contains(err.Error(), "expects raw strings assigned to _") {
+		t.Errorf("expected an error for a named c_code declaration, got %v", err)
+	}
+}
+
+func TestSymbolMap(t *testing.T) {
+	const src = `package main
+
+type led struct {
+	pin int
+}
+
+func (l *led) on() {
+	digitalWrite(l.pin, HIGH)
+}
+
+func (l led) level() int {
+	return digitalRead(l.pin)
+}
+
+func init() {
+	pinMode(13, OUTPUT)
+}
+
+//mugo:isr TIMER1_COMPA_vect
+func tick() {
+}
+
+func loop() {
+}
+`
+	var symbols bytes.Buffer
+	if err := Transpile(&bytes.Buffer{}, strings.NewReader(src), &Options{SymbolMap: &symbols}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	var got map[string]Symbol
+	if err := json.Unmarshal(symbols.Bytes(), &got); err != nil {
+		t.Fatalf("failed to read the symbol map %q: %v", symbols.String(), err)
+	}
+	want := map[string]Symbol{
+		"main.(*led).on": {Name: "led_on", File: "sketch.go", Line: 7},
+		"main.led.level": {Name: "led_level", File: "sketch.go", Line: 11},
+		"main.init":      {Name: "__mugo_init", File: "sketch.go", Line: 15},
+		"main.tick":      {Name: "TIMER1_COMPA_vect", File: "sketch.go", Line: 20},
+		"main.loop":      {Name: "loop", File: "sketch.go", Line: 23},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestQualifiedTypes(t *testing.T) {
+	const src = `package main
+
+import "io"
+
+func emit(w io.Writer, n int) {
+	w.Write(n)
+}
+`
+	for _, test := range []struct {
+		opts *Options
+		want string
+	}{
+		{&Options{Namespaces: true}, "#include <io.h>\nvoid emit(io::Writer w, int n) {\n  w.Write(n);\n}\n"},
+		{&Options{Namespaces: true, TypeMap: map[string]string{"io.Writer": "Print&"}}, "#include <io.h>\nvoid emit(Print& w, int n) {\n  w.Write(n);\n}\n"},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), test.opts); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if out.String() != test.want {
+			t.Errorf("expected:\n%s-- got:\n%s", test.want, out.String())
+		}
+	}
+	const unknown = "package main\n\nfunc emit(w io.Writer) {\n}\n"
+	if err := Transpile(&bytes.Buffer{}, strings.NewReader(unknown), nil); err == nil || !strings.Contains(err.Error(), "unsupported type io.Writer of an unknown package") {
+		t.Errorf("expected an error for a type of an unknown package, got %v", err)
+	}
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(unknown), &Options{TypeMap: map[string]string{"io.Writer": "Print&"}}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if want := "void emit(Print& w) {\n}\n"; out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestAny(t *testing.T) {
+	const src = `package main
+
+var last any
+
+func store(v any, w interface{}) {
+	last = v
+}
+`
+	const want = `void* last = 0;
+void store(void* v, void* w) {
+  last=v;
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestDestructors(t *testing.T) {
+	const src = `package main
+
+type Node struct {
+	Value int
+	Next  *Node
+	Data  *int
+}
+
+type Point struct {
+	X, Y int
+}
+`
+	const want = `struct Node;
+struct Node {
+  int Value;
+  Node* Next;
+  int* Data;
+  ~Node() {
+    delete Next;
+    delete Data;
+  }
+};
+struct Point {
+  int X;
+  int Y;
+};
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{GenerateDestructors: true, UseHeap: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+	out.Reset()
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if strings.Contains(out.String(), "~Node") {
+		t.Errorf("expected no destructor by default, got:\n%s", out.String())
+	}
+	if err := Transpile(&bytes.Buffer{}, strings.NewReader(src), &Options{GenerateDestructors: true, CppStandard: "c99"}); err == nil || !strings.Contains(err.Error(), "destructors of Node require C++") {
+		t.Errorf("expected an error in C, got %v", err)
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	const src = `package main
+
+import "fmt"
+
+func loop() {
+	err := fmt.Errorf("sensor %d failed: %v", 3, "timeout")
+	report(err)
+}
+`
+	for _, test := range []struct {
+		opts *Options
+		want string
+	}{
+		{nil, "void loop() {\n  int err = 1;\n  report(err);\n}\n"},
+		{&Options{ErrorType: "int"}, "void loop() {\n  int err = 1;\n  report(err);\n}\n"},
+		{&Options{ErrorType: "const char*"}, "void loop() {\n  const char* err = \"sensor %d failed: %v\";\n  report(err);\n}\n"},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), test.opts); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if out.String() != test.want {
+			t.Errorf("expected:\n%s-- got:\n%s", test.want, out.String())
+		}
+	}
+	if err := Transpile(&bytes.Buffer{}, strings.NewReader(src), &Options{ErrorType: "error_t"}); err == nil || !strings.Contains(err.Error(), `unknown error type "error_t"`) {
+		t.Errorf("expected an error for an unknown error type, got %v", err)
+	}
+}
+
+func TestErrorReturn(t *testing.T) {
+	const src = `package main
+
+import "fmt"
+
+func read(pin int) error {
+	if pin < 0 {
+		return fmt.Errorf("invalid pin")
+	}
+	return nil
+}
+
+func sample(pin int) (int, error) {
+	err := read(pin)
+	if err != nil {
+		return 0, err
+	}
+	return analogRead(pin), nil
+}
+
+func loop() {
+	err := read(3)
+	if err != nil {
+		return
+	}
+	err = nil
+}
+`
+	for _, test := range []struct {
+		opts *Options
+		want string
+	}{
+		{nil, `int read(int pin) {
+  if (pin<0) {
+  return 1;
+}
+  return 0;
+}
+struct sample_result {
+  int r0;
+  int r1;
+};
+sample_result sample(int pin) {
+  int err = read(pin);
+  if (err!=0) {
+  return {0, err};
+}
+  return {analogRead(pin), 0};
+}
+void loop() {
+  int err = read(3);
+  if (err!=0) {
+  return;
+}
+  err=0;
+}
+`},
+		{&Options{ErrorType: "const char*"}, `const char* read(int pin) {
+  if (pin<0) {
+  return "invalid pin";
+}
+  return NULL;
+}
+struct sample_result {
+  int r0;
+  const char* r1;
+};
+sample_result sample(int pin) {
+  const char* err = read(pin);
+  if (err!=NULL) {
+  return {0, err};
+}
+  return {analogRead(pin), NULL};
+}
+void loop() {
+  const char* err = read(3);
+  if (err!=NULL) {
+  return;
+}
+  err=NULL;
+}
+`},
+		{&Options{Target: "esp32"}, `#include "Arduino.h"
+#include <stdint.h>
+esp_err_t read(int32_t pin) {
+  if (pin<0) {
+  return 1;
+}
+  return 0;
+}
+struct sample_result {
+  int32_t r0;
+  esp_err_t r1;
+};
+sample_result sample(int32_t pin) {
+  esp_err_t err = read(pin);
+  if (err!=0) {
+  return {0, err};
+}
+  return {analogRead(pin), 0};
+}
+void loop() {
+  esp_err_t err = read(3);
+  if (err!=0) {
+  return;
+}
+  err=0;
+}
+`},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), test.opts); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if out.String() != test.want {
+			t.Errorf("expected:\n%s-- got:\n%s", test.want, out.String())
+		}
+	}
+}
+
+func TestDerefAssign(t *testing.T) {
+	const src = `package main
+
+func set(p *int) {
+	x := 5
+	*p = x
+	*p += 1
+}
+`
+	const want = `void set(int* p) {
+  int x = 5;
+  *p=x;
+  *p+=1;
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestArrayVar(t *testing.T) {
+	const src = `package main
+
+const rows = 2
+
+var buf [16]byte
+var grid [rows][3]int
+
+func loop() {
+	var samples [4]uint16
+	samples[0] = grid[1][2]
+}
+`
+	const want = `const int rows = 2;
+uint8_t buf[16] = {};
+int grid[rows][3] = {};
+void loop() {
+  uint16_t samples[4] = {};
+  samples[0]=grid[1][2];
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestRecursionWarning(t *testing.T) {
+	const src = `package main
+
+func factorial(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return n * factorial(n-1)
+}
+
+func ping(n int) {
+	if n > 0 {
+		pong(n - 1)
+	}
+}
+
+func pong(n int) {
+	ping(n)
+}
+
+func loop() {
+	ping(factorial(3))
+}
+`
+	const warnings = `#warning "mugo: recursive function factorial may cause stack overflow on small MCUs"
+#warning "mugo: recursive function ping may cause stack overflow on small MCUs"
+#warning "mugo: recursive function pong may cause stack overflow on small MCUs"
+`
+	off := false
+	for _, test := range []struct {
+		opts *Options
+		want bool
+	}{
+		{nil, false},
+		{&Options{Target: "avr"}, true},
+		{&Options{Target: "avr", RecursionWarning: &off}, false},
+	} {
+		var out bytes.Buffer
+		if err := Transpile(&out, strings.NewReader(src), test.opts); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		if got := strings.Contains(out.String(), warnings); got != test.want {
+			t.Errorf("expected the warnings to be %v with %+v, got:\n%s", test.want, test.opts, out.String())
+		}
+		if n := strings.Count(out.String(), "#warning"); !test.want && n != 0 {
+			t.Errorf("expected no warning with %+v, got:\n%s", test.opts, out.String())
+		}
+	}
+}
+
+func TestShadowing(t *testing.T) {
+	const src = `package main
+
+func loop() {
+	x := 1
+	if x > 0 {
+		x := 1.0
+		y := x
+		delay(y)
+	}
+	z := x
+	delay(z)
+}
+`
+	const want = `void loop() {
+  int x = 1;
+  if (x>0) {
+  double x = 1.0;
+  double y = x;
+  delay(y);
+}
+  int z = x;
+  delay(z);
+}
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), nil); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+}
+
+func TestMacroDefaults(t *testing.T) {
+	const src = `package main
+
+func blink(pin, ms int) {
+	if ms == 0 {
+		ms = defaultDelay()
+	}
+	if pin == 0 {
+		pin = 13
+	}
+	digitalWrite(pin, HIGH)
+	if ms == 0 {
+		ms = 1
+	}
+	delay(ms)
+}
+`
+	const want = `#define BLINK_DEFAULT_MS(val) ((val) == (0) ? (defaultDelay()) : (val))
+#define BLINK_DEFAULT_PIN(val) ((val) == (0) ? (13) : (val))
+void blink(int pin, int ms) {
+  ms=BLINK_DEFAULT_MS(ms);
+  pin=BLINK_DEFAULT_PIN(pin);
+  digitalWrite(pin, HIGH);
+  if (ms==0) {
+  ms=1;
+}
+  delay(ms);
+}
+#undef BLINK_DEFAULT_MS
+#undef BLINK_DEFAULT_PIN
+`
+	var out bytes.Buffer
+	if err := Transpile(&out, strings.NewReader(src), &Options{MacroDefaults: true}); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("expected:\n%s-- got:\n%s", want, out.String())
+	}
+
+	// nil is the zero value of the type of the parameter.
+	const nilSrc = `package main
+
+var last error
+var fallback = [2]int{1, 2}
+
+func report(err error, buf *int) {
+	if err == nil {
+		err = last
+	}
+	if buf == nil {
+		buf = &fallback[0]
+	}
+	Serial.println(*buf)
+}
+`
+	for _, tt := range []struct {
+		errorType, want string
+	}{
+		{"", "#define REPORT_DEFAULT_ERR(val) ((val) == (0) ? (last) : (val))\n"},
+		{"const char*", "#define REPORT_DEFAULT_ERR(val) ((val) == (NULL) ? (last) : (val))\n"},
+	} {
+		out.Reset()
+		if err := Transpile(&out, strings.NewReader(nilSrc), &Options{MacroDefaults: true, ErrorType: tt.errorType}); err != nil {
+			t.Fatalf("failed to transpile: %v", err)
+		}
+		want := tt.want + "#define REPORT_DEFAULT_BUF(val) ((val) == (0) ? (&fallback[0]) : (val))\n"
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected output containing:\n%s-- got:\n%s", want, out.String())
+		}
+		checkSyntax(t, "c++11", out.String())
+	}
 }
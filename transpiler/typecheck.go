@@ -0,0 +1,178 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+// typeCheck runs the type checker on the files of a package, whose imports
+// are resolved from dir, and records the types in info. Type errors are
+// ignored, as sketches call Arduino functions the type checker knows nothing
+// about: the types of the other expressions are recorded all the same. It
+// returns an error if a package of the module cannot be imported.
+func typeCheck(fset *token.FileSet, files []*ast.File, dir string, info *types.Info) error {
+	m := newModuleImporter(fset, dir)
+	conf := types.Config{Importer: m, Error: func(error) {}}
+	conf.Check(files[0].Name.Name, fset, files, info)
+	if len(m.errs) > 0 {
+		return m.errs[0]
+	}
+	return nil
+}
+
+// moduleImporter imports the packages of the module of a directory by type
+// checking their source, and the other packages with the default importer.
+// Unlike golang.org/x/tools/go/packages, which mugo would have to depend on,
+// it needs neither the go command nor the dependencies of the module: the
+// packages of a sketch are its own, or stand for C++ headers.
+type moduleImporter struct {
+	fset *token.FileSet
+	// goMod is the go.mod file of the module, or "" if there is none.
+	goMod    string
+	pkgs     map[string]*types.Package
+	fallback types.Importer
+	// errs records why packages of the module could not be imported.
+	errs []error
+}
+
+// newModuleImporter returns an importer of the packages of the module dir
+// belongs to.
+func newModuleImporter(fset *token.FileSet, dir string) *moduleImporter {
+	if dir == "" {
+		dir = "."
+	}
+	goMod, err := findGoMod(dir)
+	if err != nil {
+		goMod = ""
+	}
+	return &moduleImporter{fset: fset, goMod: goMod, pkgs: map[string]*types.Package{}, fallback: importer.Default()}
+}
+
+func (m *moduleImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := m.pkgs[path]; ok {
+		return pkg, nil
+	}
+	if m.goMod == "" {
+		return m.fallback.Import(path)
+	}
+	dir, err := ResolveImports(m.goMod, path)
+	if err != nil {
+		// Packages outside of the module, such as those standing for
+		// C++ headers, may well have no export data: expressions using
+		// them are left without a type.
+		return m.fallback.Import(path)
+	}
+	pkg, err := m.importDir(path, dir)
+	if err != nil {
+		err = fmt.Errorf("failed to import %s: %v", path, err)
+		m.errs = append(m.errs, err)
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// importDir type checks the package of the module in dir, imported as path.
+func (m *moduleImporter) importDir(path, dir string) (*types.Package, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, p := range paths {
+		if strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(m.fset, p, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	conf := types.Config{Importer: m, Error: func(error) {}}
+	pkg, _ := conf.Check(path, m.fset, files, nil)
+	m.pkgs[path] = pkg
+	return pkg, nil
+}
+
+// checkedType returns the C++ type of e recorded by Options.TypeCheck, or
+// "" if there is none.
+func checkedType(out *output, e ast.Expr) string {
+	if out.info == nil {
+		return ""
+	}
+	tv, ok := out.info.Types[e]
+	if !ok || tv.Type == nil {
+		return ""
+	}
+	return goTypeToType(out, tv.Type)
+}
+
+// goTypeToType returns the C++ type of the Go type t, or "" if it has
+// none. Named types keep their name, as all the packages share a single C++
+// namespace.
+func goTypeToType(out *output, t types.Type) string {
+	switch typ := t.(type) {
+	case *types.Basic:
+		switch typ.Kind() {
+		case types.UntypedBool:
+			return "bool"
+		case types.UntypedInt:
+			return tokenStr(out, token.INT)
+		case types.UntypedRune:
+			return basicTypes["rune"]
+		case types.UntypedFloat:
+			return tokenStr(out, token.FLOAT)
+		case types.UntypedString:
+			return tokenStr(out, token.STRING)
+		}
+		ct, _ := out.basicType(typ.Name())
+		return ct
+	case *types.Named:
+		name := typ.Obj().Name()
+		if ct, ok := out.opts.TypeMap[name]; ok {
+			return ct
+		}
+		if _, ok := typ.Underlying().(*types.Interface); ok && !out.vtable() {
+			return ""
+		}
+		return name
+	case *types.Pointer:
+		if elem := goTypeToType(out, typ.Elem()); elem != "" {
+			return elem + "*"
+		}
+	case *types.Slice:
+		if elem := goTypeToType(out, typ.Elem()); elem != "" {
+			return elem + "*"
+		}
+	case *types.Array:
+		if elem := goTypeToType(out, typ.Elem()); elem != "" {
+			return fmt.Sprintf("%s[%d]", elem, typ.Len())
+		}
+	}
+	return ""
+}
@@ -0,0 +1,334 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// methodTable describes the table of function pointers an interface is
+// dispatched through with the "vtable" interface dispatch. Each interface
+// value holds a pointer to its concrete value along with the table of the
+// methods of the concrete type.
+type methodTable struct {
+	iface   string
+	methods []tableMethod
+}
+
+// tableMethod is an entry of a methodTable.
+type tableMethod struct {
+	name   string
+	result string
+	params []string
+}
+
+// vtableUse is a concrete type converted to an interface, whose method
+// table is defined at the end of the output.
+type vtableUse struct {
+	iface    string
+	typeName string
+}
+
+// vtable reports whether interfaces are dispatched through method tables.
+func (o *output) vtable() bool {
+	return o.opts.InterfaceDispatch == "vtable"
+}
+
+// newMethodTable returns the method table of the interface named name.
+func newMethodTable(out *output, name string) (*methodTable, error) {
+	fields, err := interfaceMethods(out, name, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	mt := &methodTable{iface: name}
+	for _, f := range fields {
+		ft := f.Type.(*ast.FuncType)
+		results, err := resultTypes(out, ft)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported result type of %s.%s: %v", name, f.Names[0], err)
+		}
+		m := tableMethod{name: f.Names[0].Name, result: "void"}
+		switch len(results) {
+		case 0:
+		case 1:
+			m.result = results[0]
+		default:
+			return nil, fmt.Errorf("method %s.%s returning multiple values cannot be dispatched through a method table", name, f.Names[0])
+		}
+		for _, p := range ft.Params.List {
+			t, err := exprTypeToType(out, p.Type)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported param type of %s.%s: %v", name, f.Names[0], err)
+			}
+			for i := 0; i < len(p.Names) || i == 0; i++ {
+				m.params = append(m.params, t)
+			}
+		}
+		mt.methods = append(mt.methods, m)
+	}
+	return mt, nil
+}
+
+// tableName returns the name of the method table of typeName for the
+// interface.
+func (mt *methodTable) tableName(typeName string) string {
+	return mt.iface + "_" + typeName + "_vtable"
+}
+
+// writeTypes writes the struct of function pointers of the interface and
+// the struct of the interface values.
+func (mt *methodTable) writeTypes(out *output) {
+	fmt.Fprintf(out, "struct %s_vtable {\n", mt.iface)
+	for _, m := range mt.methods {
+		params := append([]string{"void*"}, m.params...)
+		fmt.Fprintf(out, "%s%s (*%s)(%s);\n", out.indentStr, m.result, m.name, strings.Join(params, ", "))
+	}
+	fmt.Fprintln(out, "};")
+	fmt.Fprintf(out, "struct %s {\n%[2]svoid* self;\n%[2]sconst %[1]s_vtable* vtable;\n};\n", mt.iface, out.indentStr)
+}
+
+// writeTable writes the method table of typeName for the interface, along
+// with the functions turning the untyped pointer to the value back into the
+// receiver of each method.
+func (mt *methodTable) writeTable(out *output, typeName string) error {
+	thunks := []string{}
+	for _, m := range mt.methods {
+		decl := out.methods[typeName][m.name]
+		if decl == nil {
+			return fmt.Errorf("%s does not implement %s (missing method %s)", typeName, mt.iface, m.name)
+		}
+		_, pointer, _ := receiverType(decl)
+		self := fmt.Sprintf("(%s*)self", typeName)
+		if !pointer {
+			self = "*" + self
+		}
+		params := []string{"void* self"}
+		args := []string{self}
+		for i, p := range m.params {
+			params = append(params, fmt.Sprintf("%s a%d", p, i))
+			args = append(args, fmt.Sprintf("a%d", i))
+		}
+		ret := ""
+		if m.result != "void" {
+			ret = "return "
+		}
+		thunk := fmt.Sprintf("__mugo_%s_%s_%s", mt.iface, typeName, m.name)
+		fmt.Fprintf(out, "%s %s(%s) {\n%s%s%s(%s);\n}\n", m.result, thunk, strings.Join(params, ", "), out.indentStr, ret, methodName(typeName, m.name), strings.Join(args, ", "))
+		thunks = append(thunks, thunk)
+	}
+	fmt.Fprintf(out, "const %s_vtable %s = {%s};\n", mt.iface, mt.tableName(typeName), strings.Join(thunks, ", "))
+	return nil
+}
+
+// writeTables writes the method tables used by the output, after all the
+// methods they point to.
+func writeTables(out *output) error {
+	for _, u := range *out.vtables {
+		mt, err := newMethodTable(out, u.iface)
+		if err != nil {
+			return err
+		}
+		if err := mt.writeTable(out, u.typeName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interfaceValue returns the C++ interface value holding e, converted to the
+// interface iface. Interface values point to their concrete value, so e
+// must be a pointer or a composite literal, which is stored in a temporary
+// variable declared in decls.
+func interfaceValue(out *output, iface string, e ast.Expr, decls *bytes.Buffer) (string, error) {
+	if typeFromExpr(out, e) == iface {
+		var buf bytes.Buffer
+		if err := handleExpr(out.to(&buf), e); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	if id, ok := e.(*ast.Ident); ok && id.Name == "nil" && id.Obj == nil {
+		return "{}", nil
+	}
+	t := typeFromExpr(out, e)
+	if isInterface(out, ast.NewIdent(t)) {
+		return "", fmt.Errorf("conversion of %s from interface %s to %s is not supported", types.ExprString(e), t, iface)
+	}
+	typeName := strings.TrimSuffix(t, "*")
+	if _, ok := out.types[typeName]; !ok || strings.HasSuffix(typeName, "*") || isInterface(out, ast.NewIdent(typeName)) {
+		return "", fmt.Errorf("cannot determine the concrete type of %s converted to %s", types.ExprString(e), iface)
+	}
+	var self bytes.Buffer
+	lit, ok := e.(*ast.CompositeLit)
+	if ue, isAddr := e.(*ast.UnaryExpr); isAddr && ue.Op == token.AND {
+		lit, ok = ue.X.(*ast.CompositeLit)
+	}
+	switch {
+	case ok:
+		// The literal is stored in a variable of its own.
+		if decls == nil {
+			return "", fmt.Errorf("a composite literal can only be converted to %s in a declaration or an assignment", iface)
+		}
+		tmp := out.tmp()
+		var buf bytes.Buffer
+		if err := handleCompositeLit(out.to(&buf), lit); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(decls, "%s %s = %s;\n", typeName, tmp, buf.String())
+		fmt.Fprintf(&self, "&%s", tmp)
+	case typeName == t:
+		// Go would copy the value, which would have to be allocated.
+		return "", fmt.Errorf("%s must be converted to %s by address, as interface values point to their concrete value", types.ExprString(e), iface)
+	default:
+		if err := handleExpr(out.to(&self), e); err != nil {
+			return "", err
+		}
+	}
+	mt, err := newMethodTable(out, iface)
+	if err != nil {
+		return "", err
+	}
+	if err := useTable(out, mt, typeName); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("{%s, &%s}", self.String(), mt.tableName(typeName)), nil
+}
+
+// useTable records that the method table of typeName for mt is needed and
+// declares it before the current declaration, as it is defined at the end
+// of the output.
+func useTable(out *output, mt *methodTable, typeName string) error {
+	for _, m := range mt.methods {
+		if out.methods[typeName][m.name] == nil {
+			return fmt.Errorf("%s does not implement %s (missing method %s)", typeName, mt.iface, m.name)
+		}
+	}
+	u := vtableUse{mt.iface, typeName}
+	for _, used := range *out.vtables {
+		if used == u {
+			return nil
+		}
+	}
+	*out.vtables = append(*out.vtables, u)
+	if out.before != nil {
+		fmt.Fprintf(out.before, "extern const %s_vtable %s;\n", mt.iface, mt.tableName(typeName))
+	}
+	return nil
+}
+
+// handleInterfaceCall emits a call to a method of an interface value through
+// its method table.
+func handleInterfaceCall(out *output, sel *ast.SelectorExpr, c *ast.CallExpr) error {
+	var x bytes.Buffer
+	if err := handleOperand(out.to(&x), sel.X, unaryPrec+1, false); err != nil {
+		return err
+	}
+	args := []string{x.String() + ".self"}
+	for _, a := range c.Args {
+		var buf bytes.Buffer
+		if err := handleExpr(out.to(&buf), a); err != nil {
+			return fmt.Errorf("error handling func arg expr %#v: %v", a, err)
+		}
+		args = append(args, buf.String())
+	}
+	fmt.Fprintf(out, "%s.vtable->%s(%s)", x.String(), sel.Sel.Name, strings.Join(args, ", "))
+	return nil
+}
+
+// handleInterfaceAssign emits st, assigning a value to a variable of the
+// interface type iface.
+func handleInterfaceAssign(out *output, st *ast.AssignStmt, iface string) error {
+	var decls bytes.Buffer
+	v, err := interfaceValue(out, iface, st.Rhs[0], &decls)
+	if err != nil {
+		return err
+	}
+	if v, err = interfaceLiteral(out, iface, v); err != nil {
+		return err
+	}
+	// Temporaries precede the statement, indented alike.
+	for _, d := range strings.SplitAfter(decls.String(), "\n") {
+		if d != "" {
+			fmt.Fprint(out, d, out.indentStr)
+		}
+	}
+	if err := handleExpr(out, st.Lhs[0]); err != nil {
+		return fmt.Errorf("error handling left expr %v: %v", st.Lhs[0], err)
+	}
+	fmt.Fprintf(out, "=%s", v)
+	return nil
+}
+
+// interfaceArg returns the C++ interface value holding the argument e
+// passed as a parameter of the interface type iface.
+func interfaceArg(out *output, iface string, e ast.Expr) (string, error) {
+	v, err := interfaceValue(out, iface, e, nil)
+	if err != nil {
+		return "", err
+	}
+	return interfaceLiteral(out, iface, v)
+}
+
+// interfaceLiteral turns the initializer v of an interface value, as
+// returned by interfaceValue, into an expression.
+func interfaceLiteral(out *output, iface, v string) (string, error) {
+	if !strings.HasPrefix(v, "{") {
+		return v, nil
+	}
+	if !out.features.braceInit {
+		return "", fmt.Errorf("converting a value to %s outside of a declaration requires C++11", iface)
+	}
+	return iface + v, nil
+}
+
+// interfaceResult returns the C++ type of the value returned by a call to
+// the method sel of an interface value, or "" if there is none.
+func interfaceResult(out *output, sel *ast.SelectorExpr) string {
+	iface := typeFromExpr(out, sel.X)
+	if !isInterface(out, ast.NewIdent(iface)) {
+		return ""
+	}
+	mt, err := newMethodTable(out, iface)
+	if err != nil {
+		return ""
+	}
+	for _, m := range mt.methods {
+		if m.name == sel.Sel.Name && m.result != "void" {
+			return m.result
+		}
+	}
+	return ""
+}
+
+// paramTypes returns the type of each parameter of fd, which may be nil.
+func paramTypes(fd *ast.FuncDecl) []ast.Expr {
+	if fd == nil {
+		return nil
+	}
+	var params []ast.Expr
+	for _, f := range fd.Type.Params.List {
+		for i := 0; i < len(f.Names) || i == 0; i++ {
+			params = append(params, f.Type)
+		}
+	}
+	return params
+}
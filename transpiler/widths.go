@@ -0,0 +1,147 @@
+//
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package transpiler
+
+import "go/ast"
+
+// primitiveWidths maps the supported targets to the size in bytes of Go
+// predeclared types there. The width of int, uint and pointers follows the
+// int width of the target instead.
+var primitiveWidths = map[string]map[string]int{
+	"avr": {
+		"bool":    1,
+		"byte":    1,
+		"float32": 4,
+		"float64": 4,
+		"int8":    1,
+		"int16":   2,
+		"int32":   4,
+		"int64":   8,
+		"rune":    4,
+		"string":  2,
+		"uint8":   1,
+		"uint16":  2,
+		"uint32":  4,
+		"uint64":  8,
+	},
+	"esp32": {
+		"bool":    1,
+		"byte":    1,
+		"float32": 4,
+		"float64": 8,
+		"int8":    1,
+		"int16":   2,
+		"int32":   4,
+		"int64":   8,
+		"rune":    4,
+		"string":  4,
+		"uint8":   1,
+		"uint16":  2,
+		"uint32":  4,
+		"uint64":  8,
+	},
+}
+
+// pointerWidth returns the size in bytes of pointers on the target of opts,
+// which is that of int. Targets without an int width are assumed to be AVR.
+func pointerWidth(opts *Options) int {
+	bits := opts.IntWidth
+	if bits == 0 {
+		if target := getTargetConfig(opts.Target); target != nil {
+			bits = target.IntWidth
+		}
+	}
+	if bits == 0 {
+		bits = 16
+	}
+	return bits / 8
+}
+
+// primitiveWidth returns the size in bytes of the predeclared type name on
+// the target of opts, and whether name is one.
+func primitiveWidth(name string, opts *Options) (int, bool) {
+	switch name {
+	case "int", "uint", "uintptr", "any":
+		return pointerWidth(opts), true
+	}
+	widths, ok := primitiveWidths[opts.Target]
+	if !ok {
+		widths = primitiveWidths["avr"]
+	}
+	w, ok := widths[name]
+	return w, ok
+}
+
+// TypeWidth estimates the size in bytes of a value of the type typeName on
+// the target of opts, ignoring padding. The struct types of typeTable are
+// the sum of the size of their fields; other unknown types are 0.
+func TypeWidth(typeName string, typeTable map[string]*ast.StructType, opts *Options) int {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return exprWidth(ast.NewIdent(typeName), opts, func(name string) ast.Expr {
+		if st, ok := typeTable[name]; ok {
+			return st
+		}
+		return nil
+	})
+}
+
+// typeWidth estimates the size in bytes of a value of type t, as TypeWidth
+// does, resolving the types declared in the transpiled file.
+func typeWidth(out *output, t ast.Expr) int {
+	return exprWidth(t, out.opts, func(name string) ast.Expr {
+		if ts, ok := out.types[name]; ok {
+			return ts.Type
+		}
+		return nil
+	})
+}
+
+// exprWidth estimates the size in bytes of a value of type t. lookup
+// returns the type declared with a name, or nil if there is none.
+func exprWidth(t ast.Expr, opts *Options, lookup func(string) ast.Expr) int {
+	switch typ := t.(type) {
+	case *ast.Ident:
+		if w, ok := primitiveWidth(typ.Name, opts); ok {
+			return w
+		}
+		if decl := lookup(typ.Name); decl != nil {
+			return exprWidth(decl, opts, lookup)
+		}
+		return 0
+	case *ast.StructType:
+		w := 0
+		for _, f := range typ.Fields.List {
+			n := len(f.Names)
+			if n == 0 {
+				n = 1
+			}
+			w += n * exprWidth(f.Type, opts, lookup)
+		}
+		return w
+	case *ast.ArrayType:
+		if n, ok := constArrayLen(typ); ok {
+			return n * exprWidth(typ.Elt, opts, lookup)
+		}
+		return 0
+	case *ast.StarExpr:
+		return pointerWidth(opts)
+	default:
+		return 0
+	}
+}
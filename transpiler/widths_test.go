@@ -0,0 +1,51 @@
+package transpiler
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestTypeWidth(t *testing.T) {
+	const src = `package main
+
+type point struct {
+	x, y int16
+}
+
+type segment struct {
+	from, to point
+	next     *segment
+	label    [4]byte
+	visible  bool
+}
+`
+	f, err := parser.ParseFile(token.NewFileSet(), "sketch.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	structs := map[string]*ast.StructType{}
+	for name, obj := range f.Scope.Objects {
+		if ts, ok := obj.Decl.(*ast.TypeSpec); ok {
+			structs[name] = ts.Type.(*ast.StructType)
+		}
+	}
+	for _, tc := range []struct {
+		name, target string
+		want         int
+	}{
+		{"point", "avr", 4},
+		{"segment", "avr", 15},
+		{"segment", "esp32", 17},
+		{"segment", "", 15},
+		{"int", "esp32", 4},
+		{"float64", "avr", 4},
+		{"float64", "esp32", 8},
+		{"unknown", "avr", 0},
+	} {
+		if got := TypeWidth(tc.name, structs, &Options{Target: tc.target}); got != tc.want {
+			t.Errorf("expected %s to be %d bytes on %q, got %d", tc.name, tc.want, tc.target, got)
+		}
+	}
+}
@@ -17,14 +17,474 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/googlesamples/mugo/transpiler"
 )
 
+var (
+	diagnose        = flag.Bool("diagnose", false, "report constructs that allocate memory instead of transpiling")
+	lint            = flag.Bool("lint", false, "report constructs unsuitable for an MCU instead of transpiling")
+	dumpFuncName    = flag.String("dump-func", "", "print the AST of the named function, or Type.Method, instead of transpiling")
+	listUnsupported = flag.Bool("list-unsupported", false, "summarize the unsupported constructs instead of transpiling")
+	listSupported   = flag.Bool("list-supported", false, "list the supported Go constructs and exit")
+	output          = flag.String("o", "", "output file, or \"auto\" to derive it from the input file; stdout if empty")
+	ext             = flag.String("ext", "cc", "extension of the output file with -o auto")
+	indentStyle     = flag.String("indent-style", "spaces", "indent with \"spaces\" or \"tabs\"")
+	indentWidth     = flag.Int("indent-width", 0, "number of spaces or tabs to indent with; 0 for the default")
+	format          = flag.Bool("format", false, "format the output with clang-format, if installed")
+	symbolMap       = flag.String("symbol-map", "", "JSON file to write the C++ names of the Go functions to")
+	watch           = flag.Bool("watch", false, "transpile the input file again each time it changes; requires -o")
+	watchInterval   = flag.Duration("watch-interval", 500*time.Millisecond, "how often the input file is checked for changes with -watch")
+	noCache         = flag.Bool("no-cache", false, "transpile the input file even if it is unchanged since the last time")
+	verbose         = flag.Bool("verbose", false, "log the constructs transpiled with their position")
+	compile         = flag.Bool("compile", false, "compile the output to an object file, named after the input file unless -o is set")
+	compiler        = flag.String("compiler", "avr-gcc", "compiler run with -compile")
+	mmcu            = flag.String("mmcu", "atmega328p", "microcontroller to compile for with -compile")
+	fcpu            = flag.Int("fcpu", 16000000, "clock frequency in Hz to compile for with -compile")
+	diffOnly        = flag.Bool("diff", false, "print a unified diff of the file selected by -o against the output instead of writing it; exit with 1 if they differ")
+	platformIO      = flag.String("platformio", "", "directory to create a PlatformIO project building the input file in")
+)
+
 func main() {
-	if err := transpiler.Transpile(os.Stdout, os.Stdin, os.Stderr); err != nil {
+	flag.Parse()
+	if *diagnose {
+		if err := diagnoseAllocations(os.Stdout); err != nil {
+			log.Fatalf("failed to diagnose: %v", err)
+		}
+		return
+	}
+	if *lint {
+		if err := lintForMCU(os.Stdout); err != nil {
+			log.Fatalf("failed to lint: %v", err)
+		}
+		return
+	}
+	if *dumpFuncName != "" {
+		if err := dumpInput(*dumpFuncName); err != nil {
+			log.Fatalf("failed to dump %s: %v", *dumpFuncName, err)
+		}
+		return
+	}
+	if *listSupported {
+		printSupported(os.Stdout)
+		return
+	}
+	if *listUnsupported {
+		if err := summarizeUnsupported(os.Stdout); err != nil {
+			log.Fatalf("failed to list unsupported constructs: %v", err)
+		}
+		return
+	}
+	if err := mainImpl(); errors.Is(err, errOutputDiffers) {
+		os.Exit(1)
+	} else if err != nil {
 		log.Fatalf("failed to transpile: %v", err)
 	}
 }
+
+// loadOptions returns the options read from the config file in the current
+// directory, if any, and overridden by the flags set.
+func loadOptions() (*transpiler.Options, error) {
+	opts, err := transpiler.LoadConfig(transpiler.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "ext":
+			opts.Ext = *ext
+		case "indent-style":
+			opts.IndentStyle = *indentStyle
+		case "indent-width":
+			opts.IndentWidth = *indentWidth
+		case "format":
+			opts.Format = *format
+		case "no-cache":
+			opts.NoCache = *noCache
+		case "verbose":
+			opts.Verbose = *verbose
+		case "compiler":
+			opts.Compiler = *compiler
+		case "mmcu":
+			opts.MCU = *mmcu
+		case "fcpu":
+			opts.CPUFrequency = *fcpu
+		}
+	})
+	return opts, nil
+}
+
+// mainImpl transpiles the input file, or stdin if there is none, to the
+// file selected by -o, with the options of loadOptions.
+func mainImpl() error {
+	opts, err := loadOptions()
+	if err != nil {
+		return err
+	}
+	opts.Debug = os.Stderr
+	if flag.NArg() > 1 {
+		return fmt.Errorf("expected a single input file, got %d", flag.NArg())
+	}
+	if *symbolMap != "" {
+		f, err := os.Create(*symbolMap)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		opts.SymbolMap = f
+		// The symbols are only known once transpiled.
+		opts.NoCache = true
+	}
+	in := inputFile()
+	if *compile {
+		return compileInput(in, opts)
+	}
+	if *diffOnly {
+		return diffOutput(os.Stdout, in, *output, opts)
+	}
+	if *platformIO != "" {
+		if in == "" {
+			return fmt.Errorf("-platformio requires an input file")
+		}
+		return transpiler.GeneratePlatformIO(*platformIO, in, opts)
+	}
+	if *watch {
+		if in == "" || *output == "" {
+			return fmt.Errorf("-watch requires an input file and -o")
+		}
+		out := *output
+		if out == "auto" {
+			out = ""
+		}
+		watchInput(in, out, opts, *watchInterval, nil)
+		return nil
+	}
+	if in == "" {
+		if *output == "auto" {
+			return fmt.Errorf("-o auto requires an input file")
+		}
+		if *output == "" {
+			return transpiler.Transpile(os.Stdout, os.Stdin, opts)
+		}
+		// Like TranspileFile, only write the output if transpilation
+		// succeeds.
+		var buf bytes.Buffer
+		if err := transpiler.Transpile(&buf, os.Stdin, opts); err != nil {
+			return err
+		}
+		return os.WriteFile(*output, buf.Bytes(), 0644)
+	}
+	switch *output {
+	case "":
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return transpiler.Transpile(os.Stdout, f, opts)
+	case "auto":
+		return transpiler.TranspileFile(in, "", opts)
+	default:
+		return transpiler.TranspileFile(in, *output, opts)
+	}
+}
+
+// compileInput transpiles the input file, or stdin if in is empty, and
+// compiles the result to the file selected by -o, which defaults to in with
+// the .o extension.
+func compileInput(in string, opts *transpiler.Options) error {
+	obj := *output
+	if obj == "" || obj == "auto" {
+		if in == "" {
+			return fmt.Errorf("-compile requires -o or an input file")
+		}
+		obj = transpiler.OutputPath(in, "o")
+	}
+	if in != "" {
+		return transpiler.CompileFile(in, obj, opts)
+	}
+	src, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	// The errors of the compiler refer to the Go source.
+	opts.LineDirectives = true
+	var buf bytes.Buffer
+	if _, err := transpiler.TranspileBytes(&buf, src, opts); err != nil {
+		return err
+	}
+	return transpiler.Compile(buf.Bytes(), obj, opts)
+}
+
+// errOutputDiffers is returned by diffOutput when the output file is out of
+// date.
+var errOutputDiffers = errors.New("the output differs")
+
+// diffOutput transpiles in and writes to w the unified diff of the file out,
+// or in with the extension opts.Ext if out is "auto", against the result,
+// which returns errOutputDiffers unless it is empty. A missing out is
+// compared as empty.
+func diffOutput(w io.Writer, in, out string, opts *transpiler.Options) error {
+	if in == "" || out == "" {
+		return fmt.Errorf("-diff requires an input file and -o")
+	}
+	if out == "auto" {
+		out = transpiler.OutputPath(in, opts.Ext)
+	}
+	src, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	if opts.TypeCheck && opts.Dir == "" {
+		opts.Dir = filepath.Dir(in)
+	}
+	var buf bytes.Buffer
+	if _, err := transpiler.TranspileBytes(&buf, src, opts); err != nil {
+		return err
+	}
+	old, err := os.ReadFile(out)
+	switch {
+	case os.IsNotExist(err):
+		old = nil
+	case err != nil:
+		return err
+	}
+	if bytes.Equal(old, buf.Bytes()) {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "mugo-diff")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	before, after := filepath.Join(dir, "before"), filepath.Join(dir, "after")
+	if err := os.WriteFile(before, old, 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(after, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	cmd := exec.Command("diff", "-u", "-L", out, "-L", out+" (transpiled)", before, after)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	var exit *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exit) && exit.ExitCode() == 1 {
+		return errOutputDiffers
+	} else if err != nil {
+		return fmt.Errorf("failed to run diff: %v", err)
+	}
+	// diff found no difference after all, as with different line endings.
+	return nil
+}
+
+// watchInput transpiles in to out, as TranspileFile does, then again each
+// time the modification time of in changes, which is checked every interval,
+// until stop is closed. Errors are logged and do not stop the watch: in may
+// be briefly missing while an editor saves it by replacing it.
+func watchInput(in, out string, opts *transpiler.Options, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last time.Time
+	var statErr string
+	for {
+		fi, err := os.Stat(in)
+		if err != nil {
+			// The error is only logged once until in is back.
+			if err.Error() != statErr {
+				log.Printf("failed to watch %s: %v", in, err)
+			}
+			statErr = err.Error()
+		} else {
+			statErr = ""
+			if !fi.ModTime().Equal(last) {
+				last = fi.ModTime()
+				if err := transpiler.TranspileFile(in, out, opts); err != nil {
+					log.Printf("failed to transpile %s: %v", in, err)
+				} else {
+					log.Printf("transpiled %s", in)
+				}
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// inputFile returns the file given as argument or, when run by go generate
+// with a directive such as
+//
+//	//go:generate µ -o auto
+//
+// the file holding the directive. It returns "" if there is neither.
+func inputFile() string {
+	if flag.NArg() > 0 {
+		return flag.Arg(0)
+	}
+	return os.Getenv("GOFILE")
+}
+
+// dumpInput prints the AST of the function name of the input file, or
+// stdin if there is none.
+func dumpInput(name string) error {
+	f, filename, err := openInput()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return dumpFunc(os.Stdout, f, filename, name)
+}
+
+// dumpFunc writes to w the AST of the function name, or of the method
+// Type.Method, of the Go source read from src.
+func dumpFunc(w io.Writer, src io.Reader, filename, name string) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("failed to parse file: %v", err)
+	}
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		n := fd.Name.Name
+		if fd.Recv != nil && len(fd.Recv.List) == 1 {
+			t := fd.Recv.List[0].Type
+			if star, ok := t.(*ast.StarExpr); ok {
+				t = star.X
+			}
+			n = types.ExprString(t) + "." + n
+		}
+		if n == name {
+			return ast.Fprint(w, fset, fd, nil)
+		}
+	}
+	return fmt.Errorf("no function %s in %s", name, filename)
+}
+
+// openInput opens the input file, or returns stdin if there is none, along
+// with the file name positions are reported in.
+func openInput() (io.ReadCloser, string, error) {
+	in := inputFile()
+	if in == "" {
+		return io.NopCloser(os.Stdin), "sketch.go", nil
+	}
+	f, err := os.Open(in)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, in, nil
+}
+
+// parseInput parses the input file, or stdin if there is none.
+func parseInput(fset *token.FileSet) (*ast.File, error) {
+	src, filename, err := openInput()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	f, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file: %v", err)
+	}
+	return f, nil
+}
+
+// diagnoseAllocations writes to w the constructs of the input file, or
+// stdin if there is none, that allocate memory.
+func diagnoseAllocations(w io.Writer) error {
+	fset := token.NewFileSet()
+	f, err := parseInput(fset)
+	if err != nil {
+		return err
+	}
+	for _, d := range transpiler.DiagnoseAllocations(f) {
+		fmt.Fprintf(w, "%v: %v: %s\n", fset.Position(d.Pos), d.Severity, d.Message)
+	}
+	return nil
+}
+
+// lintForMCU writes to w the constructs of the input file, or stdin if
+// there is none, that are unsuitable for an MCU.
+func lintForMCU(w io.Writer) error {
+	fset := token.NewFileSet()
+	f, err := parseInput(fset)
+	if err != nil {
+		return err
+	}
+	for _, l := range transpiler.LintForMCU(f) {
+		fmt.Fprintf(w, "%v: %v: %s\n", fset.Position(l.Pos), l.Severity, l.Message)
+	}
+	return nil
+}
+
+// printSupported lists the Go constructs that can be transpiled to w,
+// sorted by AST node type.
+func printSupported(w io.Writer) {
+	nodes := transpiler.SupportedNodes()
+	names := []string{}
+	for n := range nodes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(w, "%-18s %s\n", n, nodes[n])
+	}
+}
+
+// summarizeUnsupported writes to w the number of occurrences of each
+// unsupported construct of the input file, or stdin if there is none,
+// transpiled with the options of loadOptions.
+func summarizeUnsupported(w io.Writer) error {
+	opts, err := loadOptions()
+	if err != nil {
+		return err
+	}
+	opts.SkipUnsupported = true
+	src, _, err := openInput()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	err = transpiler.Transpile(io.Discard, src, opts)
+	errs, ok := err.(transpiler.ErrorList)
+	if !ok {
+		return err
+	}
+	summary := transpiler.SummarizeUnsupported(errs)
+	names := []string{}
+	for n := range summary {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		occurrences := "occurrences"
+		if summary[n] == 1 {
+			occurrences = "occurrence"
+		}
+		fmt.Fprintf(w, "%d %s of %s", summary[n], occurrences, n)
+		if d := transpiler.DescribeNode(n); d != "" {
+			fmt.Fprintf(w, " (%s)", d)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
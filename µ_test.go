@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googlesamples/mugo/transpiler"
+)
+
+func TestGoGenerate(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "blink.go")
+	src := "package main\n\n//go:generate µ -o auto\n\nfunc loop() {\n\tdelay(1)\n}\n"
+	if err := os.WriteFile(in, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOFILE", in)
+	defer func(o string) { *output = o }(*output)
+	*output = "auto"
+
+	if got := inputFile(); got != in {
+		t.Errorf("expected the input file to be %s, got %s", in, got)
+	}
+	if err := mainImpl(); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "blink.cc"))
+	if err != nil {
+		t.Fatalf("failed to read the output: %v", err)
+	}
+	if want := "void loop() {\n  delay(1);\n}\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestStdinOutput(t *testing.T) {
+	t.Setenv("GOFILE", "")
+	defer func(o string) { *output = o }(*output)
+	*output = filepath.Join(t.TempDir(), "sketch.cc")
+	defer func(f *os.File) { os.Stdin = f }(os.Stdin)
+	transpile := func(src string) error {
+		t.Helper()
+		in := filepath.Join(t.TempDir(), "stdin")
+		if err := os.WriteFile(in, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		os.Stdin = f
+		return mainImpl()
+	}
+
+	if err := transpile("package main\n\nfunc loop() {\n\tgo delay(1)\n}\n"); err == nil {
+		t.Fatal("expected an error transpiling a goroutine")
+	}
+	if _, err := os.Stat(*output); !os.IsNotExist(err) {
+		t.Errorf("expected no output file after an error, got %v", err)
+	}
+	if err := transpile("package main\n\nfunc loop() {\n\tdelay(1)\n}\n"); err != nil {
+		t.Fatalf("failed to transpile: %v", err)
+	}
+	got, err := os.ReadFile(*output)
+	if err != nil {
+		t.Fatalf("failed to read the output: %v", err)
+	}
+	if want := "void loop() {\n  delay(1);\n}\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestReportInput(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "sketch.go")
+	src := "package main\n\nvar name = \"led\"\n\nfunc loop() {\n\tgo delay(1)\n\tbuf := make([]byte, 4)\n\tSerial.println(\"pin \" + name, buf)\n}\n"
+	if err := os.WriteFile(in, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOFILE", in)
+	for _, test := range []struct {
+		report func(io.Writer) error
+		want   string
+	}{
+		{diagnoseAllocations, in + ":7:9: warning: call to make allocates memory\n"},
+		{lintForMCU, in + ":8:17: warning: string concatenation allocates memory\n"},
+		{summarizeUnsupported, "1 occurrence of *ast.GoStmt (goroutines)\n"},
+	} {
+		var out bytes.Buffer
+		if err := test.report(&out); err != nil {
+			t.Errorf("failed to report on %s: %v", in, err)
+		}
+		if !strings.Contains(out.String(), test.want) {
+			t.Errorf("expected %q in:\n%s", test.want, out.String())
+		}
+	}
+
+	// The unsupported constructs are those of the configured options.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(transpiler.ConfigFile, []byte(`{"IndentStyle": "mixed"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := summarizeUnsupported(io.Discard); err == nil {
+		t.Errorf("expected the invalid indent style of %s to be reported", transpiler.ConfigFile)
+	}
+}
+
+func TestDumpFunc(t *testing.T) {
+	const src = `package main
+
+type led struct{}
+
+func (l *led) on() {
+	digitalWrite(13, HIGH)
+}
+
+func setup() {
+	pinMode(13, OUTPUT)
+}
+
+func loop() {
+	delay(1000)
+}
+`
+	var out bytes.Buffer
+	if err := dumpFunc(&out, strings.NewReader(src), "sketch.go", "loop"); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "     0  *ast.FuncDecl {") || !strings.Contains(out.String(), `Name: "loop"`) || !strings.Contains(out.String(), `Name: "delay"`) {
+		t.Errorf("expected the AST of loop, got:\n%s", out.String())
+	}
+	for _, other := range []string{`Name: "setup"`, `Name: "pinMode"`, `Name: "led"`} {
+		if strings.Contains(out.String(), other) {
+			t.Errorf("expected only the AST of loop, found %s in:\n%s", other, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := dumpFunc(&out, strings.NewReader(src), "sketch.go", "led.on"); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	if !strings.Contains(out.String(), `Name: "digitalWrite"`) {
+		t.Errorf("expected the AST of led.on, got:\n%s", out.String())
+	}
+	if err := dumpFunc(&out, strings.NewReader(src), "sketch.go", "blink"); err == nil {
+		t.Errorf("expected an error for a missing function")
+	}
+}
+
+func TestPrintSupported(t *testing.T) {
+	var out bytes.Buffer
+	printSupported(&out)
+	for _, want := range []string{"*ast.IfStmt", "*ast.ReturnStmt", "*ast.AssignStmt", "*ast.CallExpr"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %s in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDiffOutput(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("diff is not available")
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "blink.go")
+	if err := os.WriteFile(in, []byte("package main\n\nfunc loop() {\n\tdelay(10)\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "blink.cc")
+	if err := os.WriteFile(out, []byte("void loop() {\n  delay(100);\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var diff bytes.Buffer
+	if err := diffOutput(&diff, in, out, &transpiler.Options{}); !errors.Is(err, errOutputDiffers) {
+		t.Fatalf("expected the output to differ, got %v", err)
+	}
+	for _, want := range []string{"--- " + out + "\n", "-  delay(100);\n", "+  delay(10);\n"} {
+		if !strings.Contains(diff.String(), want) {
+			t.Errorf("expected %q in the diff:\n%s", want, diff.String())
+		}
+	}
+	if bs, err := os.ReadFile(out); err != nil || !strings.Contains(string(bs), "delay(100)") {
+		t.Errorf("expected the output file to be left alone, got %q (%v)", bs, err)
+	}
+
+	if err := transpiler.TranspileFile(in, out, &transpiler.Options{NoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	diff.Reset()
+	if err := diffOutput(&diff, in, out, &transpiler.Options{}); err != nil || diff.Len() != 0 {
+		t.Errorf("expected no diff, got %q (%v)", diff.String(), err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "blink.go")
+	out := filepath.Join(dir, "blink.cc")
+	if err := os.WriteFile(in, []byte("package main\n\nfunc loop() {\n\tdelay(1)\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watchInput(in, out, &transpiler.Options{}, 10*time.Millisecond, stop)
+		close(done)
+	}()
+	waitFor := func(want string) {
+		t.Helper()
+		var got []byte
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if got, _ = os.ReadFile(out); string(got) == want {
+				return
+			}
+		}
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	waitFor("void loop() {\n  delay(1);\n}\n")
+
+	if err := os.WriteFile(in, []byte("package main\n\nfunc loop() {\n\tdelay(2)\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Make the change visible on file systems with a coarse modification
+	// time.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(in, later, later); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("void loop() {\n  delay(2);\n}\n")
+
+	// Editors save by replacing the file, which is missing for a while.
+	if err := os.Remove(in); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(in, []byte("package main\n\nfunc loop() {\n\tdelay(3)\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("void loop() {\n  delay(3);\n}\n")
+
+	close(stop)
+	<-done
+}